@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorBean is the error envelope of every /api/v1 route
+type ErrorBean struct {
+	Result *bool       `json:"result"`
+	Error  ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errBadRequest        = &apiError{http.StatusBadRequest, "bad_request", "request body is not valid json"}
+	errNotFound          = &apiError{http.StatusNotFound, "not_found", "no such route"}
+	errMethodNotAllowed  = &apiError{http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed on this route"}
+	errRoomNotFound      = &apiError{http.StatusNotFound, "room_not_found", "room is not exist"}
+	errUserNotFound      = &apiError{http.StatusNotFound, "user_not_found", "user is not exist in this room"}
+	errInvalidRoomName   = &apiError{http.StatusUnprocessableEntity, "invalid_room_name", "roomName is empty"}
+	errInvalidUserName   = &apiError{http.StatusUnprocessableEntity, "invalid_user_name", "userName is empty"}
+	errRoomEmpty         = &apiError{http.StatusConflict, "room_empty", "no user in this room"}
+	errTopicNotFound     = &apiError{http.StatusNotFound, "topic_not_found", "no topic is found"}
+	errInvalidDifficulty = &apiError{http.StatusUnprocessableEntity, "invalid_difficulty", "difficulty must be easy, medium, hard or mixed"}
+	errInvalidLocale     = &apiError{http.StatusUnprocessableEntity, "invalid_locale", "locale is not valid, e.g. zh-TW or en"}
+	errTooManyRooms      = &apiError{http.StatusServiceUnavailable, "too_many_rooms", "the server has too many rooms, try again later"}
+	errRoomFull          = &apiError{http.StatusConflict, "room_full", "room has no more seats"}
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		appLog.error("json marshal fail", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(jsonBytes))
+}
+
+func writeAPIError(w http.ResponseWriter, apiErr *apiError) {
+	result := false
+	writeJSON(w, apiErr.Status, ErrorBean{&result, ErrorDetail{apiErr.Code, apiErr.Message}})
+}
+
+// apiRoute is one /api/v1 route, pattern segments starting with ':' match any value.
+// Request and Response are values of the json bodies the handler decodes and writes on success,
+// nil for none, validateOpenAPISpec checks them against the operation in openapi.json
+type apiRoute struct {
+	Method   string
+	Pattern  string
+	Handler  func(w http.ResponseWriter, r *http.Request, params map[string]string)
+	Request  interface{}
+	Response interface{}
+}
+
+var apiV1Routes = []apiRoute{
+	{http.MethodGet, "/rooms", apiRoomListHandler, nil, []RoomBean{}},
+	{http.MethodPost, "/rooms", apiRoomCreateHandler, RoomBean{}, RoomBean{}},
+	{http.MethodGet, "/rooms/:roomId", apiRoomHandler, nil, RoomBean{}},
+	{http.MethodPost, "/rooms/:roomId/users", apiRoomJoinHandler, UserJoinRoomBean{}, UserJoinRoomBean{}},
+	{http.MethodDelete, "/rooms/:roomId/users/:userId", apiRoomQuitHandler, nil, UserJoinRoomBean{}},
+	{http.MethodPost, "/rooms/:roomId/draws", apiRoomStartDrawHandler, nil, TopicDetail{}},
+	{http.MethodGet, "/rooms/:roomId/topic", apiRoomTopicHandler, nil, TopicDetail{}},
+	{http.MethodPut, "/rooms/:roomId/customWords", apiRoomCustomWordsHandler, CustomWordsBean{}, CustomWordsBean{}},
+	{http.MethodPut, "/rooms/:roomId/packs", apiRoomPacksHandler, RoomPacksBean{}, RoomPacksBean{}},
+	{http.MethodGet, "/admin/rooms", adminRoute(apiRoomListAllHandler), nil, map[string]*Room{}},
+	{http.MethodDelete, "/admin/rooms", adminRoute(apiRoomCleanAllHandler), nil, nil},
+	{http.MethodPost, "/admin/topics/reload", adminRoute(apiTopicReloadHandler), nil, TopicReloadReport{}},
+	{http.MethodGet, "/admin/topics/stats", adminRoute(apiTopicStatsHandler), nil, []TopicStat{}},
+	{http.MethodPost, "/admin/topics/import", adminRoute(apiTopicImportHandler), TopicBundle{}, ImportReport{}},
+	{http.MethodGet, "/admin/topics/export", adminRoute(apiTopicExportHandler), nil, TopicBundle{}},
+	{http.MethodPost, "/admin/categories", adminRoute(apiCategoryCreateHandler), CategoryBean{}, CategoryBean{}},
+	{http.MethodPut, "/admin/categories/:category", adminRoute(apiCategoryRenameHandler), CategoryBean{}, CategoryBean{}},
+	{http.MethodDelete, "/admin/categories/:category", adminRoute(apiCategoryDeleteHandler), nil, nil},
+	{http.MethodPost, "/admin/categories/:category/topics", adminRoute(apiTopicAddHandler), Topic{}, CategoryBean{}},
+	{http.MethodDelete, "/admin/categories/:category/topics/:topic", adminRoute(apiTopicRemoveHandler), nil, CategoryBean{}},
+	{http.MethodGet, "/topics", apiTopicListHandler, nil, map[string]*Topic{}},
+	{http.MethodGet, "/topics/random", apiTopicRandomHandler, nil, TopicDetail{}},
+	{http.MethodGet, "/packs", apiPackListHandler, nil, []PackBean{}},
+	{http.MethodPost, "/players", apiPlayerCreateHandler, PlayerProfile{}, PlayerProfile{}},
+	{http.MethodGet, "/players/:playerId", apiPlayerHandler, nil, PlayerProfile{}},
+	{http.MethodPut, "/players/:playerId", apiPlayerRenameHandler, PlayerProfile{}, PlayerProfile{}},
+	{http.MethodGet, "/history", apiHistoryListHandler, nil, []*GameRecord{}},
+	{http.MethodGet, "/history/:gameId", apiHistoryHandler, nil, GameRecord{}},
+	{http.MethodGet, "/history/players/:userId", apiPlayerHistoryHandler, nil, []*GameRecord{}},
+	{http.MethodGet, "/leaderboards/all", leaderboardRoute(boardAll, apiLeaderboardHandler), nil, LeaderboardPage{}},
+	{http.MethodGet, "/leaderboards/all/players/:playerId", leaderboardRoute(boardAll, apiLeaderboardRankHandler), nil, LeaderboardEntry{}},
+	{http.MethodGet, "/leaderboards/weekly", leaderboardRoute("weekly", apiLeaderboardHandler), nil, LeaderboardPage{}},
+	{http.MethodGet, "/leaderboards/weekly/players/:playerId", leaderboardRoute("weekly", apiLeaderboardRankHandler), nil, LeaderboardEntry{}},
+	{http.MethodGet, "/leaderboards/categories/:category", apiLeaderboardHandler, nil, LeaderboardPage{}},
+	{http.MethodGet, "/leaderboards/categories/:category/players/:playerId", apiLeaderboardRankHandler, nil, LeaderboardEntry{}},
+	{http.MethodGet, "/packs/:packId", apiPackHandler, nil, TopicPack{}},
+	{http.MethodGet, "/openapi.json", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		openAPIHandler(w, r)
+	}, nil, json.RawMessage{}},
+}
+
+func matchRoute(pattern string, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, ":") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+			params[part[1:]] = pathParts[i]
+		} else if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// apiV1Handler dispatches /api/v1/* by method and path
+func apiV1Handler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	pathMatched := false
+	for _, route := range apiV1Routes {
+		params, ok := matchRoute(route.Pattern, path)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+		if route.Method == r.Method {
+			route.Handler(w, r, params)
+			return
+		}
+	}
+	if pathMatched {
+		writeAPIError(w, errMethodNotAllowed)
+		return
+	}
+	writeAPIError(w, errNotFound)
+}
+
+func apiRoomListHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, listRoomBeans())
+}
+
+func apiRoomCreateHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if !checkRate(actionRoomCreate, w, r) {
+		return
+	}
+	roomBean := &RoomBean{}
+	err := json.NewDecoder(r.Body).Decode(roomBean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	if !checkPlayerRate(actionRoomCreate, roomBean.PlayerId, roomBean.PlayerToken, w) {
+		return
+	}
+	respRoomBean, apiErr := createRoom(roomBean)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusCreated, respRoomBean)
+}
+
+func apiRoomHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	roomBean, apiErr := getRoomBean(params["roomId"])
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, roomBean)
+}
+
+func apiRoomJoinHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if !checkRate(actionRoomJoin, w, r) {
+		return
+	}
+	userJoinRoomBean := &UserJoinRoomBean{}
+	err := json.NewDecoder(r.Body).Decode(userJoinRoomBean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	if !checkPlayerRate(actionRoomJoin, userJoinRoomBean.PlayerId, userJoinRoomBean.PlayerToken, w) {
+		return
+	}
+	userJoinRoomBean.RoomId = params["roomId"]
+	apiErr := joinRoom(userJoinRoomBean)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusCreated, userJoinRoomBean)
+}
+
+func apiRoomQuitHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	userJoinRoomBean, apiErr := quitRoom(params["roomId"], params["userId"])
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, userJoinRoomBean)
+}
+
+func apiRoomStartDrawHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	topicDetail, apiErr := startDraw(params["roomId"])
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, topicDetail)
+}
+
+func apiRoomTopicHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	topicDetail, apiErr := getRoomTopicDetail(params["roomId"])
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, topicDetail)
+}
+
+func apiRoomListAllHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, allRooms())
+}
+
+func apiRoomCleanAllHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	cleanAllRooms()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiTopicListHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		writeJSON(w, http.StatusOK, topicStore.All())
+		return
+	}
+	writeJSON(w, http.StatusOK, localeTopics(lang))
+}
+
+func apiTopicRandomHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	picked := randomTopic(requestLocale(r.URL.Query().Get("lang")), r.URL.Query().Get("difficulty"))
+	if picked == nil {
+		writeAPIError(w, errTopicNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, TopicDetail{Category: picked.Category, Topic: picked.Topic})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var configFile = "" // json file of the settings, CONFIG_FILE or -config
+
+var host = ""                                // listen host, empty listens on every interface
+var logLevelName = "info"                    // debug, info, warn or error
+var homeTemplate = "template/html/home.html" // page of /
+var assetLinksFile = ".well-known/assetlinks.json"
+
+// setting is one tunable, read from the config file by key, then from the environment by env,
+// then from the flag named key in kebab case, e.g. -data-dir, the last one set wins
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	value  interface{}   // *string, *int, *bool, *[]string or *time.Duration
+	unit   time.Duration // of a *time.Duration, e.g. time.Second for a _SEC setting
+	min    int           // of an int or a duration in unit
+	check  func(value string) string
+	secret bool // hidden by -print-config
+}
+
+var settings = []*setting{
+	// server
+	{key: "host", env: "HOST", value: &host, usage: "listen host, empty listens on every interface"},
+	{key: "port", env: "PORT", value: &port, usage: "listen port", check: checkPort},
+	{key: "logLevel", env: "LOG_LEVEL", value: &logLevelName, usage: "debug, info, warn or error", check: checkLogLevel},
+	{key: "adminToken", env: "ADMIN_TOKEN", value: &adminToken, secret: true, usage: "bearer token of the admin api, empty disables it"},
+	{key: "allowedOrigins", env: "ALLOWED_ORIGINS", value: &allowedOrigins, check: checkOrigin, usage: "comma separated origins allowed to open a websocket besides the server itself, * allows any"},
+	{key: "allowNoOrigin", env: "ALLOW_NO_ORIGIN", value: &allowNoOrigin, usage: "allow websockets without an Origin header, e.g. of the native apps"},
+	// files
+	{key: "dataDir", env: "DATA_DIR", value: &dataDir, usage: "directory of the file stores and the room snapshot"},
+	{key: "topicDir", env: "TOPIC_DIR", value: &topicDir, usage: "directory of config.json and the category files", check: checkDir},
+	{key: "packDir", env: "PACK_DIR", value: &packDir, usage: "directory of the word packs"},
+	{key: "roomSnapshotFile", env: "ROOM_SNAPSHOT_FILE", value: &roomSnapshotFile, usage: "room snapshot, dataDir/rooms.json if empty"},
+	{key: "homeTemplate", env: "HOME_TEMPLATE", value: &homeTemplate, usage: "html template of /"},
+	{key: "assetLinksFile", env: "ASSET_LINKS_FILE", value: &assetLinksFile, usage: "file served as /.well-known/assetlinks.json"},
+	{key: "openAPIFile", env: "OPENAPI_FILE", value: &openAPIFile, usage: "openapi spec served as /openapi.json"},
+	// stores
+	{key: "roomStore", env: "ROOM_STORE", value: &roomStoreType, usage: "memory or file", check: checkStoreType},
+	{key: "topicStore", env: "TOPIC_STORE", value: &topicStoreType, usage: "memory or file", check: checkStoreType},
+	{key: "historyStore", env: "HISTORY_STORE", value: &historyStoreType, usage: "memory or file", check: checkStoreType},
+	{key: "profileStore", env: "PROFILE_STORE", value: &profileStoreType, usage: "memory or file", check: checkStoreType},
+	{key: "statStore", env: "STAT_STORE", value: &statStoreType, usage: "memory or file", check: checkStoreType},
+	{key: "historyLimit", env: "HISTORY_LIMIT", value: &historyLimit, min: 1, usage: "finished games kept in memory"},
+	// rooms
+	{key: "maxRooms", env: "MAX_ROOMS", value: &maxRooms, usage: "rooms open at once, 0 means no limit"},
+	{key: "maxRoomUsers", env: "MAX_ROOM_USERS", value: &maxRoomUsers, usage: "users in a room, 0 means no limit"},
+	{key: "maxRoomPacks", env: "MAX_ROOM_PACKS", value: &maxRoomPacks, usage: "word packs enabled in a room"},
+	{key: "maxCustomWords", env: "MAX_CUSTOM_WORDS", value: &maxCustomWords, usage: "custom words of a room"},
+	{key: "maxCustomWordLength", env: "MAX_CUSTOM_WORD_LENGTH", value: &maxCustomWordLength, min: 1, usage: "characters of a custom word"},
+	{key: "maxDisplayNameLength", env: "MAX_DISPLAY_NAME_LENGTH", value: &maxDisplayNameLength, min: 1, usage: "characters of a player display name"},
+	{key: "defaultLocale", env: "DEFAULT_LOCALE", value: &defaultLocale, usage: "locale of the plain topics and of rooms without one", check: checkLocale},
+	// rounds and scores
+	{key: "roundTimeLimitSec", env: "ROUND_TIME_LIMIT_SEC", value: &roundTimeLimit, unit: time.Second, usage: "correct guesses after it score nothing, 0 means no limit"},
+	{key: "guessScore", env: "GUESS_SCORE", value: &guessScore, usage: "score of the first correct guess of a round"},
+	{key: "guessScoreStep", env: "GUESS_SCORE_STEP", value: &guessScoreStep, usage: "score less for each guesser before"},
+	{key: "minGuessScore", env: "MIN_GUESS_SCORE", value: &minGuessScore, usage: "lowest score of a correct guess"},
+	{key: "drawScore", env: "DRAW_SCORE", value: &drawScore, usage: "score of the drawer for each correct guesser"},
+	{key: "drawBatchWindowMs", env: "DRAW_BATCH_WINDOW_MS", value: &drawBatchWindow, unit: time.Millisecond, usage: "draw frames relayed together to a mux socket, 0 relays every frame at once"},
+	{key: "drawMaxFps", env: "DRAW_MAX_FPS", value: &drawMaxFramesPerSecond, usage: "draw frames a user can send per second, 0 means no limit"},
+	// rate limits
+	{key: "roomCreatesPerMinute", env: "ROOM_CREATES_PER_MIN", value: &roomCreatesPerMinute, usage: "rooms an ip can create per minute, 0 means no limit"},
+	{key: "roomJoinsPerMinute", env: "ROOM_JOINS_PER_MIN", value: &roomJoinsPerMinute, usage: "joins of an ip per minute, 0 means no limit"},
+	{key: "answersPerMinute", env: "ANSWERS_PER_MIN", value: &answersPerMinute, usage: "answers of a user per minute, 0 means no limit"},
+	{key: "chatsPerMinute", env: "CHATS_PER_MIN", value: &chatsPerMinute, usage: "other room messages of a user per minute, 0 means no limit"},
+	{key: "playerRoomCreatesPerMinute", env: "PLAYER_ROOM_CREATES_PER_MIN", value: &playerRoomCreatesPerMinute, usage: "rooms a player profile can create per minute, 0 means no limit"},
+	{key: "playerRoomJoinsPerMinute", env: "PLAYER_ROOM_JOINS_PER_MIN", value: &playerRoomJoinsPerMinute, usage: "joins of a player profile per minute, 0 means no limit"},
+	{key: "ipAnswersPerMinute", env: "IP_ANSWERS_PER_MIN", value: &ipAnswersPerMinute, usage: "answers of the users of an ip per minute, 0 means no limit"},
+	{key: "ipChatsPerMinute", env: "IP_CHATS_PER_MIN", value: &ipChatsPerMinute, usage: "other room messages of the users of an ip per minute, 0 means no limit"},
+	{key: "trustProxy", env: "TRUST_PROXY", value: &trustProxy, usage: "take the client ip of the rate limits from the last X-Forwarded-For entry, on by default on heroku"},
+	// connections and timers
+	{key: "reconnectGraceSec", env: "RECONNECT_GRACE_SEC", value: &reconnectGrace, unit: time.Second, min: 1, usage: "restored users not reconnected in time quit the room"},
+	{key: "roomSnapshotIntervalSec", env: "ROOM_SNAPSHOT_INTERVAL_SEC", value: &roomSnapshotInterval, unit: time.Second, usage: "0 only saves on shutdown"},
+	{key: "topicReloadIntervalSec", env: "TOPIC_RELOAD_INTERVAL_SEC", value: &topicReloadInterval, unit: time.Second, usage: "how often the topic files are checked, 0 disables it"},
+	{key: "maxDrawFrameBytes", env: "MAX_DRAW_FRAME_BYTES", value: &maxDrawFrameBytes, min: 64, usage: "a bigger draw frame closes the socket"},
+	{key: "maxImportBytes", env: "MAX_IMPORT_BYTES", value: &maxImportBytes, min: 1024, usage: "a bigger topic import is rejected"},
+	{key: "maxRoomFrameBytes", env: "MAX_ROOM_FRAME_BYTES", value: &maxRoomFrameBytes, min: 64, usage: "a bigger room message closes the socket"},
+	{key: "pingIntervalSec", env: "PING_INTERVAL_SEC", value: &pingInterval, unit: time.Second, min: 1, usage: "how often the server pings every websocket"},
+	{key: "pongTimeoutSec", env: "PONG_TIMEOUT_SEC", value: &pongTimeout, unit: time.Second, min: 1, usage: "a websocket without any frame or pong for this long is dropped like a quit"},
+	{key: "shutdownTimeoutSec", env: "SHUTDOWN_TIMEOUT_SEC", value: &shutdownTimeout, unit: time.Second, min: 1, usage: "sockets still open then are closed without waiting"},
+	{key: "sseKeepAliveSec", env: "SSE_KEEP_ALIVE_SEC", value: &sseKeepAliveInterval, unit: time.Second, min: 1, usage: "keep-alive comment interval of the sse streams"},
+	{key: "pollWaitSec", env: "POLL_WAIT_SEC", value: &pollWaitTimeout, unit: time.Second, min: 1, usage: "how long a long poll waits for events"},
+	{key: "pollIdleSec", env: "POLL_IDLE_SEC", value: &pollIdleTimeout, unit: time.Second, min: 1, usage: "a long polling user quits the room after it without any poll"},
+}
+
+func checkPort(value string) string {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 65535 {
+		return "must be a number from 1 to 65535"
+	}
+	return ""
+}
+
+func checkLogLevel(value string) string {
+	if _, ok := parseLogLevel(value); !ok {
+		return "must be debug, info, warn or error"
+	}
+	return ""
+}
+
+func checkStoreType(value string) string {
+	if value != storeMemory && value != storeFile {
+		return "must be memory or file"
+	}
+	return ""
+}
+
+func checkLocale(value string) string {
+	if !validLocale(value) {
+		return "must be a locale, e.g. zh-TW or en"
+	}
+	return ""
+}
+
+func checkOrigin(value string) string {
+	if value == "*" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return "must be * or a scheme and host, e.g. https://example.com"
+	}
+	return ""
+}
+
+func checkDir(value string) string {
+	info, err := os.Stat(value)
+	if err != nil {
+		return "is not accessible: " + err.Error()
+	}
+	if !info.IsDir() {
+		return "is not a directory"
+	}
+	return ""
+}
+
+func findSetting(key string) *setting {
+	for _, s := range settings {
+		if s.key == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// flagName is the kebab case of key, e.g. data-dir of dataDir
+func (s *setting) flagName() string {
+	name := ""
+	for _, c := range s.key {
+		if c >= 'A' && c <= 'Z' {
+			name += "-" + string(c-'A'+'a')
+		} else {
+			name += string(c)
+		}
+	}
+	return name
+}
+
+// set parses value into the setting, it returns why value is not valid
+func (s *setting) set(value string) string {
+	switch v := s.value.(type) {
+	case *string:
+		value = strings.TrimSpace(value)
+		if s.check != nil {
+			if failure := s.check(value); failure != "" {
+				return failure
+			}
+		}
+		*v = value
+	case *[]string:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if s.check != nil {
+				if failure := s.check(item); failure != "" {
+					return item + " " + failure
+				}
+			}
+			items = append(items, item)
+		}
+		*v = items
+	case *bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "must be true or false"
+		}
+		*v = b
+	case *int, *time.Duration:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < s.min {
+			return fmt.Sprintf("must be an integer of at least %d", s.min)
+		}
+		if d, ok := v.(*time.Duration); ok {
+			*d = time.Duration(n) * s.unit
+		} else {
+			*v.(*int) = n
+		}
+	}
+	return ""
+}
+
+// String is the current value in the form set reads
+func (s *setting) String() string {
+	switch v := s.value.(type) {
+	case *string:
+		return *v
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	case *time.Duration:
+		return strconv.Itoa(int(*v / s.unit))
+	}
+	return ""
+}
+
+// registerConfigFlags adds -config and one flag per setting, call it before flag.Parse
+func registerConfigFlags() {
+	flag.StringVar(&configFile, "config", "", "json file of the settings, the environment and the flags override it")
+	for _, s := range settings {
+		flag.String(s.flagName(), "", s.usage+" (env "+s.env+")")
+	}
+}
+
+// readConfigFile reads the settings of fileName by key, numbers and lists are turned into strings
+func readConfigFile(fileName string) (map[string]string, error) {
+	raw := map[string]interface{}{}
+	if err := readJSONFile(fileName, &raw); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []interface{}:
+			items := []string{}
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// loadConfig applies the config file, the environment and the flags, then checks the settings
+// together, it returns every invalid setting with where it came from
+func loadConfig() []string {
+	failures := []string{}
+	bySource := func(source string, values map[string]string, name func(s *setting) string) {
+		// source is where the values come from, e.g. "env " names the setting by its environment variable
+		for _, s := range settings {
+			value, exist := values[name(s)]
+			if !exist {
+				continue
+			}
+			if failure := s.set(value); failure != "" {
+				failures = append(failures, fmt.Sprintf("%s%s %q: %s", source, name(s), value, failure))
+			}
+		}
+	}
+
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			failures = append(failures, "config file "+configFile+": "+err.Error())
+		} else {
+			bySource(configFile+" ", values, func(s *setting) string { return s.key })
+			for key := range values {
+				if findSetting(key) == nil {
+					failures = append(failures, fmt.Sprintf("%s %s: unknown setting", configFile, key))
+				}
+			}
+		}
+	}
+	env := map[string]string{}
+	for _, s := range settings {
+		if v, exist := os.LookupEnv(s.env); exist && v != "" {
+			env[s.env] = v
+		}
+	}
+	bySource("env ", env, func(s *setting) string { return s.env })
+	flags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	bySource("flag -", flags, func(s *setting) string { return s.flagName() })
+
+	for _, dir := range []*string{&dataDir, &topicDir, &packDir} {
+		if !strings.HasSuffix(*dir, "/") {
+			*dir += "/"
+		}
+	}
+	if roomSnapshotFile == "" {
+		roomSnapshotFile = dataDir + "rooms.json"
+	}
+	logLevel, _ = parseLogLevel(logLevelName)
+	if minGuessScore > guessScore {
+		failures = append(failures, fmt.Sprintf("minGuessScore %d: must not be more than guessScore %d", minGuessScore, guessScore))
+	}
+	if pongTimeout <= pingInterval {
+		failures = append(failures, fmt.Sprintf("pongTimeoutSec %d: must be more than pingIntervalSec %d", pongTimeout/time.Second, pingInterval/time.Second))
+	}
+	return failures
+}
+
+// configValues is every setting by key in the form of the config file, for -print-config
+func configValues() map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, s := range settings {
+		switch v := s.value.(type) {
+		case *string:
+			values[s.key] = *v
+			if s.secret && *v != "" {
+				values[s.key] = "***"
+			}
+		case *[]string:
+			values[s.key] = append([]string{}, *v...)
+		case *bool:
+			values[s.key] = *v
+		default:
+			n, _ := strconv.Atoi(s.String())
+			values[s.key] = n
+		}
+	}
+	return values
+}
+
+func printConfig() error {
+	jsonBytes, err := json.MarshalIndent(configValues(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(jsonBytes))
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	customWordsReplace = "replace" // only the custom words are drawn
+	customWordsMix     = "mix"     // custom words are mixed with the global topics
+	customCategory     = "custom"
+)
+
+var maxCustomWords = 200
+var maxCustomWordLength = 32 // in characters
+
+// CustomWordsBean sets the custom words of a room, userId must be the host
+type CustomWordsBean struct {
+	UserId          string   `json:"userId,omitempty"`
+	UserToken       string   `json:"userToken,omitempty"` // secret of userId from the join response
+	CustomWords     []string `json:"customWords"`
+	CustomWordsMode string   `json:"customWordsMode,omitempty"`
+	Result          *bool    `json:"result,omitempty"`
+}
+
+var (
+	errInvalidCustomWords = &apiError{http.StatusUnprocessableEntity, "invalid_custom_words", "custom words are empty, too many or too long"}
+	errInvalidCustomMode  = &apiError{http.StatusUnprocessableEntity, "invalid_custom_words_mode", "customWordsMode must be replace or mix"}
+	errNotHost            = &apiError{http.StatusForbidden, "not_host", "only the host of the room can change this setting"}
+)
+
+// checkCustomWords trims and dedups the words, an empty list clears the custom words
+func checkCustomWords(words []string, mode string) ([]string, string, *apiError) {
+	if len(words) == 0 {
+		return nil, "", nil
+	}
+	if mode == "" {
+		mode = customWordsReplace
+	}
+	if mode != customWordsReplace && mode != customWordsMix {
+		return nil, "", errInvalidCustomMode
+	}
+	if len(words) > maxCustomWords {
+		return nil, "", errInvalidCustomWords
+	}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" || utf8.RuneCountInString(word) > maxCustomWordLength {
+			return nil, "", errInvalidCustomWords
+		}
+	}
+	return uniqueTopics(nil, words), mode, nil
+}
+
+// randomRoomTopic picks the topic of the next round in the room locale and difficulty
+// with the packs and the custom words of the room
+func randomRoomTopic(room *Room) *pickedTopic {
+	words := room.CustomWords
+	if len(words) == 0 {
+		return randomTopic(roomLocale(room), room.Difficulty, room.Packs...)
+	}
+	if room.CustomWordsMode == customWordsMix {
+		// pick a custom word in proportion to the size of the global topics
+		globalCount := countLocaleTopics(roomLocale(room), room.Packs...)
+		if rand.Intn(globalCount+len(words)) < globalCount {
+			return randomTopic(roomLocale(room), room.Difficulty, room.Packs...)
+		}
+	}
+	word := words[rand.Intn(len(words))]
+	return &pickedTopic{customCategory, word, []string{word}, ""}
+}
+
+func clearCustomWords(room *Room) {
+	room.CustomWords = nil
+	room.CustomWordsMode = ""
+}
+
+// isRoomHost is true if userId is the host and token is its secret, the userIds are public
+// so the id alone proves nothing, a user restored without a token can not change the settings
+func isRoomHost(room *Room, userId string, token string) bool {
+	if userId == "" || room.HostUserId != userId {
+		return false
+	}
+	user, exist := room.Users.Get(userId)
+	return exist && user.UserToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(user.UserToken)) == 1
+}
+
+func setCustomWords(roomId string, bean *CustomWordsBean) *apiError {
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return errRoomNotFound
+	}
+	if !isRoomHost(room, bean.UserId, bean.UserToken) {
+		return errNotHost
+	}
+	bean.UserToken = "" // not written back
+	words, mode, apiErr := checkCustomWords(bean.CustomWords, bean.CustomWordsMode)
+	if apiErr != nil {
+		return apiErr
+	}
+	room.CustomWords = words
+	room.CustomWordsMode = mode
+	bean.CustomWords = words
+	bean.CustomWordsMode = mode
+	saveRoom(room)
+	return nil
+}
+
+func apiRoomCustomWordsHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &CustomWordsBean{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	if apiErr := setCustomWords(params["roomId"], bean); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	result := true
+	bean.Result = &result
+	writeJSON(w, http.StatusOK, bean)
+}
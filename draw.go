@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var drawBatchWindow = 0 * time.Millisecond // 0 means relay every frame at once
+var drawMaxFramesPerSecond = 0             // 0 means no limit
+
+// tokenBucket allows rate frames per second with a burst of the same size
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// idle is the time since the bucket was last used
+func (b *tokenBucket) idle(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return now.Sub(b.last)
+}
+
+func (b *tokenBucket) allow() bool {
+	if b == nil || b.rate <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens -= 1
+	return true
+}
+
+// allowDrawFrame takes a token of the frame limit of the sender, a dropped frame is logged and counted
+func allowDrawFrame(limiter *tokenBucket, hc *hubConn) bool {
+	if limiter.allow() {
+		return true
+	}
+	drawDrops.inc("")
+	hc.log.debug("drop frame, too many frames")
+	return false
+}
+
+// drawBatcher coalesces the draw frames sent to one user within drawBatchWindow.
+// only a mux socket gets batches, text frames holding json are merged into one json array
+// on the drawBatch channel, others are sent as is. a legacy draw socket gets every frame as sent.
+type drawBatcher struct {
+	mutex   sync.Mutex
+	conn    *hubConn
+	pending []json.RawMessage
+	timer   *time.Timer
+	closed  bool
+}
+
+func newDrawBatcher(conn *hubConn) *drawBatcher {
+	return &drawBatcher{conn: conn}
+}
+
+func (b *drawBatcher) send(mtype int, msg []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil
+	}
+	if drawBatchWindow <= 0 || !b.conn.mux || mtype != websocket.TextMessage || !json.Valid(msg) {
+		if err := b.flushLocked(); err != nil {
+			return err
+		}
+		return b.conn.write(channelDraw, mtype, msg)
+	}
+	b.pending = append(b.pending, json.RawMessage(append([]byte(nil), msg...)))
+	if b.timer == nil {
+		b.timer = time.AfterFunc(drawBatchWindow, b.flush)
+	}
+	return nil
+}
+
+func (b *drawBatcher) flush() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return
+	}
+	err := b.flushLocked()
+	if err != nil {
+		b.conn.log.warn("write fail", "err", err)
+	}
+}
+
+func (b *drawBatcher) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+	pending := b.pending
+	b.pending = nil
+	if len(pending) == 1 {
+		return b.conn.write(channelDraw, websocket.TextMessage, pending[0])
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, frame := range pending {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(frame)
+	}
+	buf.WriteByte(']')
+	return b.conn.write(channelDrawBatch, websocket.TextMessage, buf.Bytes())
+}
+
+func (b *drawBatcher) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.pending = nil
+	b.closed = true
+}
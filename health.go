@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+var startedAt = time.Now()
+
+var (
+	errShuttingDownCheck = errors.New("server is shutting down")
+	errNoTopicsCheck     = errors.New("no topic is loaded")
+)
+
+// HealthBean is the response of /healthz and /readyz
+type HealthBean struct {
+	Status string        `json:"status"` // ok or fail
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// DebugState is the summary of /debug/state
+type DebugState struct {
+	StartedAt    time.Time         `json:"startedAt"`
+	Uptime       float64           `json:"uptimeSeconds"`
+	Goroutines   int               `json:"goroutines"`
+	HeapBytes    uint64            `json:"heapBytes"`
+	ShuttingDown bool              `json:"shuttingDown"`
+	RoomCount    int               `json:"roomCount"`
+	UserCount    int               `json:"userCount"`
+	Sockets      int               `json:"sockets"` // open websockets
+	Categories   int               `json:"categories"`
+	Words        int               `json:"words"`
+	Packs        int               `json:"packs"`
+	Rooms        []DebugRoomState  `json:"rooms"`
+	Stores       map[string]string `json:"stores"`
+	RateLimited  map[string]int    `json:"rateLimited"` // rejected by action since start
+}
+
+// DebugRoomState counts the users and connections of a room
+type DebugRoomState struct {
+	RoomId       string `json:"roomId"`
+	RoomName     string `json:"roomName"`
+	Users        int    `json:"users"`
+	RoomSockets  int    `json:"roomSockets"`
+	DrawSockets  int    `json:"drawSockets"`
+	EventStreams int    `json:"eventStreams"` // sse and long polling clients
+	Playing      bool   `json:"playing"`
+}
+
+// storeChecker is a store that can tell if its storage is reachable
+type storeChecker interface {
+	Check() error
+}
+
+// checkDirWritable creates and removes a file in dir
+func checkDirWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".check-")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func (s *fileTopicStore) Check() error {
+	return checkDirWritable(s.dir)
+}
+
+func (s *fileRoomStore) Check() error {
+	return checkDirWritable(s.dir)
+}
+
+func (s *fileHistoryStore) Check() error {
+	if _, err := s.file.Stat(); err != nil {
+		return err
+	}
+	return checkDirWritable(filepath.Dir(s.fileName))
+}
+
+func (s *fileProfileStore) Check() error {
+	return checkDirWritable(filepath.Dir(s.fileName))
+}
+
+func (s *fileStatStore) Check() error {
+	return checkDirWritable(filepath.Dir(s.fileName))
+}
+
+// readyChecks are ok when the server is not shutting down, topics are loaded and every store is reachable
+func readyChecks() []HealthCheck {
+	checks := []HealthCheck{}
+	add := func(name string, err error) {
+		check := HealthCheck{Name: name, Ok: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+	if isShuttingDown() {
+		add("shutdown", errShuttingDownCheck)
+	}
+	if len(topicStore.Categories()) == 0 {
+		add("topics", errNoTopicsCheck)
+	} else {
+		add("topics", nil)
+	}
+	stores := []struct {
+		name  string
+		store interface{}
+	}{{"roomStore", roomStore}, {"topicStore", topicStore}, {"historyStore", historyStore}, {"profileStore", profileStore}, {"statStore", statStore}}
+	for _, item := range stores {
+		if checker, ok := item.store.(storeChecker); ok {
+			add(item.name, checker.Check())
+		}
+	}
+	return checks
+}
+
+func debugState() *DebugState {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	state := &DebugState{StartedAt: startedAt, Uptime: time.Since(startedAt).Seconds(), Goroutines: runtime.NumGoroutine(),
+		HeapBytes: memStats.HeapAlloc, ShuttingDown: isShuttingDown(), Packs: len(getPacks()), Rooms: []DebugRoomState{}, RateLimited: rateLimitCounts(),
+		Stores: map[string]string{"room": roomStoreType, "topic": topicStoreType, "history": historyStoreType, "profile": profileStoreType,
+			"stat": statStoreType}}
+	liveConns.Lock()
+	state.Sockets = len(liveConns.conns)
+	liveConns.Unlock()
+	for _, topic := range topicStore.All() {
+		state.Categories++
+		state.Words += len(topic.Words)
+	}
+	for _, room := range roomStore.List() {
+		room.mutex.Lock()
+		playing := room.game != nil
+		room.mutex.Unlock()
+		roomState := DebugRoomState{RoomId: room.RoomId, RoomName: room.RoomName, Users: room.Users.Count(), Playing: playing}
+		for _, user := range room.Users.List() {
+			if roomConn := user.RoomConn; roomConn != nil {
+				if roomConn.conn != nil {
+					roomState.RoomSockets++
+				} else {
+					roomState.EventStreams++
+				}
+			}
+			if user.DrawConn != nil {
+				roomState.DrawSockets++
+			}
+		}
+		state.RoomCount++
+		state.UserCount += roomState.Users
+		state.Rooms = append(state.Rooms, roomState)
+	}
+	sort.Slice(state.Rooms, func(i, j int) bool {
+		return state.Rooms[i].RoomId < state.Rooms[j].RoomId
+	})
+	return state
+}
+
+// healthzHandler is the liveness probe, ok as long as the server answers
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthBean{Status: "ok"})
+}
+
+// readyzHandler is the readiness probe, 503 if a check fails
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	bean := HealthBean{Status: "ok", Checks: readyChecks()}
+	status := http.StatusOK
+	for _, check := range bean.Checks {
+		if !check.Ok {
+			bean.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, bean)
+}
+
+// debugStateHandler serves /debug/state, the admin token is required
+func debugStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, debugState())
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var historyLimit = 1000 // finished games kept in memory, older ones are only in the history file
+
+// GameRecord is a game, the rounds played until every user has drawn once or the room closed
+type GameRecord struct {
+	GameId    string        `json:"gameId"`
+	RoomId    string        `json:"roomId"`
+	RoomName  string        `json:"roomName"`
+	StartedAt time.Time     `json:"startedAt"`
+	EndedAt   *time.Time    `json:"endedAt,omitempty"`
+	Players   []*GamePlayer `json:"players"`
+	Rounds    []*GameRound  `json:"rounds"`
+	Winners   []string      `json:"winners,omitempty"` // userIds with the top score
+}
+
+type GamePlayer struct {
+	UserId   string `json:"userId"`
+	UserName string `json:"userName"`
+	PlayerId string `json:"playerId,omitempty"`
+	Score    int    `json:"score"`
+}
+
+type GameRound struct {
+	DrawerId   string       `json:"drawerId"`
+	DrawerName string       `json:"drawerName"`
+	Category   string       `json:"category"`
+	Topic      string       `json:"topic"`
+	StartedAt  time.Time    `json:"startedAt"`
+	EndedAt    *time.Time   `json:"endedAt,omitempty"`
+	Guesses    []*GameGuess `json:"guesses"`
+}
+
+// GameGuess is a correct guess, seconds after the round started
+type GameGuess struct {
+	UserId   string  `json:"userId"`
+	UserName string  `json:"userName"`
+	Seconds  float64 `json:"seconds"`
+	Score    int     `json:"score"`
+}
+
+var errGameNotFound = &apiError{http.StatusNotFound, "game_not_found", "game is not exist"}
+
+func (game *GameRecord) player(user *User) *GamePlayer {
+	for _, player := range game.Players {
+		if player.UserId == user.UserId {
+			return player
+		}
+	}
+	player := &GamePlayer{UserId: user.UserId, UserName: user.UserName, PlayerId: user.PlayerId}
+	game.Players = append(game.Players, player)
+	return player
+}
+
+func (game *GameRecord) lastRound() *GameRound {
+	if len(game.Rounds) == 0 {
+		return nil
+	}
+	return game.Rounds[len(game.Rounds)-1]
+}
+
+// copyGame copies the game with its players, rounds and guesses
+func copyGame(game *GameRecord) *GameRecord {
+	if game == nil {
+		return nil
+	}
+	gameCopy := *game
+	gameCopy.Players = make([]*GamePlayer, 0, len(game.Players))
+	for _, player := range game.Players {
+		playerCopy := *player
+		gameCopy.Players = append(gameCopy.Players, &playerCopy)
+	}
+	gameCopy.Rounds = make([]*GameRound, 0, len(game.Rounds))
+	for _, round := range game.Rounds {
+		roundCopy := *round
+		roundCopy.Guesses = make([]*GameGuess, 0, len(round.Guesses))
+		for _, guess := range round.Guesses {
+			guessCopy := *guess
+			roundCopy.Guesses = append(roundCopy.Guesses, &guessCopy)
+		}
+		gameCopy.Rounds = append(gameCopy.Rounds, &roundCopy)
+	}
+	gameCopy.Winners = append([]string(nil), game.Winners...)
+	return &gameCopy
+}
+
+// startGameRound adds the round just dispatched in startDraw to the game of the room,
+// a new game starts with the scores of the users reset
+func startGameRound(room *Room, picked *pickedTopic) {
+	now := time.Now()
+	if room.game == nil {
+		room.game = &GameRecord{GameId: generateUuId(), RoomId: room.RoomId, RoomName: room.RoomName, StartedAt: now,
+			Players: []*GamePlayer{}, Rounds: []*GameRound{}}
+		for _, user := range room.Users.List() {
+			user.Score = 0
+			room.game.player(user)
+		}
+	}
+	if last := room.game.lastRound(); last != nil && last.EndedAt == nil {
+		last.EndedAt = &now
+	}
+	round := &GameRound{DrawerId: room.TopicDetail.CurrentDrawUserId, Category: picked.Category, Topic: picked.Topic,
+		StartedAt: now, Guesses: []*GameGuess{}}
+	if user, exist := room.Users.Get(round.DrawerId); exist {
+		round.DrawerName = room.game.player(user).UserName
+	}
+	room.game.Rounds = append(room.game.Rounds, round)
+}
+
+// recordGameGuess adds a correct guess scored by scoreCorrectGuess to the current round
+func recordGameGuess(room *Room, user *User, score int) {
+	if room.game == nil || room.game.lastRound() == nil {
+		return
+	}
+	round := room.game.lastRound()
+	room.game.player(user)
+	round.Guesses = append(round.Guesses, &GameGuess{user.UserId, user.UserName,
+		time.Since(round.StartedAt).Seconds(), score})
+}
+
+// gameFinishing tells whether the next round would start a new rotation of drawers
+func gameFinishing(room *Room) bool {
+	if room.game == nil || room.Users.Count() == 0 {
+		return false
+	}
+	return (room.CurrentDrawOrder+1)%room.Users.Count() == 0
+}
+
+// finishGame takes the game off the room and sums its scores, it runs under the room mutex
+// and the caller writes the game with recordGame after unlocking
+func finishGame(room *Room) *GameRecord {
+	game := room.game
+	room.game = nil
+	if game == nil || len(game.Rounds) == 0 {
+		return nil
+	}
+	now := time.Now()
+	game.EndedAt = &now
+	if last := game.lastRound(); last.EndedAt == nil {
+		last.EndedAt = &now
+	}
+	for _, user := range room.Users.List() {
+		game.player(user)
+	}
+	scores := map[string]int{}
+	for _, round := range game.Rounds {
+		for _, guess := range round.Guesses {
+			scores[guess.UserId] += guess.Score
+			scores[round.DrawerId] += drawScore
+		}
+	}
+	topScore := 0
+	for _, player := range game.Players {
+		player.Score = scores[player.UserId]
+		if player.Score > topScore {
+			topScore = player.Score
+		}
+	}
+	for _, player := range game.Players {
+		if topScore > 0 && player.Score == topScore {
+			game.Winners = append(game.Winners, player.UserId)
+		}
+	}
+	return game
+}
+
+// recordGame writes a game taken by finishGame to the history, the profiles and the leaderboards
+func recordGame(game *GameRecord) {
+	if game == nil {
+		return
+	}
+	if err := historyStore.Add(game); err != nil {
+		roomLog(game.RoomId, "").error("game record fail", "gameId", game.GameId, "err", err)
+	}
+	addGameStats(game)
+	addGameToLeaderboards(game)
+}
+
+func historyQueryLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return 20
+	}
+	if limit > 100 {
+		return 100
+	}
+	return limit
+}
+
+func apiHistoryListHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, historyStore.Recent(historyQueryLimit(r)))
+}
+
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	game, exist := historyStore.Get(params["gameId"])
+	if !exist {
+		writeAPIError(w, errGameNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, game)
+}
+
+func apiPlayerHistoryHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, historyStore.ByPlayer(params["userId"], historyQueryLimit(r)))
+}
+
+// historyHandler serves /history/* as an alias of /api/v1/history/*
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	r.URL.Path = "/api/v1" + r.URL.Path
+	apiV1Handler(w, r)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryStore(t *testing.T) {
+	eachBackend(t, func(t *testing.T, reopen func()) {
+		for i, gameId := range []string{"g1", "g2", "g3"} {
+			game := &GameRecord{GameId: gameId, RoomId: "r1", StartedAt: time.Unix(int64(i), 0).UTC(),
+				Players: []*GamePlayer{{UserId: "u" + gameId, UserName: "Ann", PlayerId: "p1"}}}
+			if err := historyStore.Add(game); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reopen()
+		if game, exist := historyStore.Get("g2"); !exist || game.RoomId != "r1" {
+			t.Fatalf("g2 %+v", game)
+		}
+		recent := historyStore.Recent(2)
+		if len(recent) != 2 || recent[0].GameId != "g3" || recent[1].GameId != "g2" {
+			t.Fatalf("recent %v, want g3 g2", recent)
+		}
+		if games := historyStore.ByPlayer("p1", 10); len(games) != 3 {
+			t.Fatalf("games of p1 %d, want 3", len(games))
+		}
+		if games := historyStore.ByPlayer("ug1", 10); len(games) != 1 {
+			t.Fatalf("games of ug1 %d, want 1", len(games))
+		}
+		gameIds := []string{}
+		if err := historyStore.Each(func(game *GameRecord) { gameIds = append(gameIds, game.GameId) }); err != nil {
+			t.Fatal(err)
+		}
+		if len(gameIds) != 3 || gameIds[0] != "g1" {
+			t.Fatalf("each %v, want the oldest first", gameIds)
+		}
+	})
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	channelDraw      = "draw"
+	channelRoom      = "room"
+	channelDrawBatch = "drawBatch" // only sent to a mux socket, the data is a json array of draw frames
+)
+
+var allowedOrigins []string // origins allowed to open a websocket besides the server itself, * allows any
+var allowNoOrigin = true    // native apps send no Origin header
+
+var maxDrawFrameBytes = 64 * 1024   // a bigger frame closes the socket
+var maxRoomFrameBytes = 4 * 1024    // of a room message, the multiplexed socket allows the bigger of the two
+var pingInterval = 20 * time.Second // how often the server pings every websocket
+var pongTimeout = 60 * time.Second  // a socket without any frame or pong for this long is dropped
+
+// Envelope wraps every text frame on the multiplexed /ws/{roomId} socket.
+// binary frames on that socket are always draw data and are not wrapped.
+type Envelope struct {
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// hubConn is one connection of a user, either a legacy single channel socket
+// (/ws/draw/, /ws/room/), a multiplexed one (/ws/) carrying both channels,
+// or a room event stream of a sse or long polling client (conn is nil).
+type hubConn struct {
+	id     string
+	conn   *websocket.Conn
+	events chan []byte
+	mutex  sync.Mutex // websocket allows only one writer at a time
+	mux    bool
+	log    *logger       // with roomId, userId and connId
+	done   chan struct{} // closed to stop the pings
+	once   sync.Once
+}
+
+func newHubConn(conn *websocket.Conn, mux bool, connLog *logger) *hubConn {
+	hc := &hubConn{id: generateUuId(), conn: conn, mux: mux, done: make(chan struct{})}
+	hc.log = connLog.with("connId", hc.id)
+	return hc
+}
+
+// read reads the next data frame, every frame or pong of the client moves the read deadline on
+func (c *hubConn) read() (int, []byte, error) {
+	mtype, msg, err := c.conn.ReadMessage()
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			c.log.info("heartbeat timeout", "timeout", pongTimeout)
+			socketDrops.inc("heartbeat_timeout")
+		} else if err == websocket.ErrReadLimit {
+			c.log.warn("frame too large")
+			socketDrops.inc("frame_too_large")
+		}
+		return mtype, msg, err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	return mtype, msg, nil
+}
+
+// frameTooLarge closes the socket if msg is over the limit of its channel, the read limit
+// of a mux socket is the larger of the two channel limits
+func (c *hubConn) frameTooLarge(msg []byte, limit int) bool {
+	if len(msg) <= limit {
+		return false
+	}
+	c.log.warn("frame too large", "bytes", len(msg), "limit", limit)
+	socketDrops.inc("frame_too_large")
+	c.closeWith(websocket.CloseMessageTooBig, "frame too large")
+	return true
+}
+
+// keepAlive pings the client every pingInterval until the socket is closed
+func (c *hubConn) keepAlive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mutex.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval))
+			c.mutex.Unlock()
+			if err != nil {
+				c.log.debug("ping fail", "err", err)
+				return
+			}
+		}
+	}
+}
+
+func (c *hubConn) write(channel string, mtype int, msg []byte) error {
+	if c.events != nil {
+		select {
+		case c.events <- msg:
+		default:
+			c.log.warn("events are full, drop message")
+		}
+		return nil
+	}
+	if c.mux && mtype == websocket.TextMessage {
+		data := msg
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(msg))
+		}
+		envelope, err := json.Marshal(Envelope{channel, data})
+		if err != nil {
+			return err
+		}
+		msg = envelope
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.WriteMessage(mtype, msg)
+}
+
+func (c *hubConn) pong() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.WriteMessage(websocket.PongMessage, []byte("pong"))
+}
+
+func (c *hubConn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	removeLiveConn(c)
+	c.once.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// closeWith sends a close frame, the socket is closed once the client answers it
+func (c *hubConn) closeWith(code int, text string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
+
+// getRoomUser checks the room exist and the user is login in it
+func getRoomUser(roomId string, userId string) (*Room, *User, bool) {
+	if userId == "" {
+		return nil, nil, false
+	}
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return nil, nil, false
+	}
+	user, userExist := room.Users.Get(userId)
+	if !userExist {
+		return nil, nil, false
+	}
+	return room, user, true
+}
+
+// originAllowed checks the Origin header of a websocket upgrade against allowedOrigins,
+// a page of the server itself is always allowed
+func originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return allowNoOrigin
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeHubConn upgrades to a websocket reading frames up to readLimit bytes,
+// it is pinged every pingInterval and dropped without an answer in pongTimeout
+func upgradeHubConn(w http.ResponseWriter, r *http.Request, name string, mux bool, readLimit int, connLog *logger) (*hubConn, error) {
+	if isShuttingDown() {
+		writeAPIError(w, errShuttingDown)
+		return nil, errors.New(errShuttingDown.Message)
+	}
+	upgrader := &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			if originAllowed(r) {
+				return true
+			}
+			connLog.warn("origin rejected", "handler", name, "origin", r.Header.Get("Origin"), "remoteAddr", r.RemoteAddr)
+			originRejections.inc(name)
+			return false
+		},
+	}
+	conn, err := upgrader.Upgrade(w, r, nil) // get *conn
+	if err != nil {
+		return nil, err
+	}
+	hc := newHubConn(conn, mux, connLog.with("handler", name))
+	if !addLiveConn(hc) {
+		hc.closeWith(websocket.CloseGoingAway, "server shutdown")
+		conn.Close()
+		return nil, errors.New(errShuttingDown.Message)
+	}
+	conn.SetReadLimit(int64(readLimit))
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	conn.SetPingHandler(func(s string) error {
+		hc.log.debug("get ping")
+		hc.pong()
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	go hc.keepAlive()
+	return hc, nil
+}
+
+func attachDrawConn(user *User, hc *hubConn) {
+	socketsGauge.inc(channelDraw)
+	if user.drawBatcher != nil { // of a draw connection replaced by hc
+		user.drawBatcher.close()
+	}
+	user.drawBatcher = newDrawBatcher(hc)
+	user.DrawConn = hc
+}
+
+// detachDrawConn clears the draw connection of user if it is still hc,
+// an older connection closing late leaves the newer one alone
+func detachDrawConn(user *User, hc *hubConn) {
+	socketsGauge.add(channelDraw, -1)
+	if user.DrawConn != hc {
+		return
+	}
+	user.DrawConn = nil
+	if user.drawBatcher != nil {
+		user.drawBatcher.close()
+	}
+}
+
+func attachRoomConn(user *User, hc *hubConn) {
+	result := true
+	user.Ready = &result
+	user.RoomConn = hc
+	if hc.conn != nil {
+		socketsGauge.inc(channelRoom)
+	}
+	// send others you join
+	sendAction(user, "join")
+}
+
+// detachRoomConn clears the room connection of user and quits the room if it is still hc,
+// an older connection closing late leaves the newer one and the user alone
+func detachRoomConn(user *User, hc *hubConn) {
+	if hc.conn != nil {
+		socketsGauge.add(channelRoom, -1)
+	}
+	if user.RoomConn != hc {
+		return
+	}
+	user.RoomConn = nil
+	if isShuttingDown() { // keep the user in the room snapshot to reconnect after restart
+		return
+	}
+	// send others you quit
+	sendAction(user, "quit")
+	userQuitRoom(user.RoomId, user)
+}
+
+// userQuitRoom removes the user from the room after the room socket closed
+func userQuitRoom(roomId string, user *User) {
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return
+	}
+	// adjust drawOrder
+	adjustDrawOrder(room, user.DrawOrder)
+	if room.TopicDetail != nil {
+		if room.TopicDetail.CurrentDrawUserId == user.UserId {
+			room.TopicDetail.NextDrawUserId = getNextDrawOrderUserId(room)
+		}
+	}
+	//remove user form room's user map
+	removeRoomUser(room, user.UserId)
+}
+
+// unwrapDrawData turns the data of a mux draw envelope into the frame of a legacy draw socket,
+// a json string is the plain text frame, write wraps it again for the mux sockets
+func unwrapDrawData(data json.RawMessage) []byte {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		return []byte(text)
+	}
+	return data
+}
+
+// relayDrawFrame sends a draw frame of currentUser to the other users in the room
+func relayDrawFrame(roomId string, currentUserId string, mtype int, msg []byte) bool {
+	currentRoom, exist := roomStore.Get(roomId) // get current room
+	if !exist {
+		return false
+	}
+	roomUsers := currentRoom.Users // get the users in this room
+	for _, user := range roomUsers.List() {
+		if user.UserId != currentUserId { // do not send msg to (s)hseself
+			drawConn := user.DrawConn
+			if drawConn == nil || user.drawBatcher == nil {
+				roomLog(roomId, user.UserId).debug("draw conn is nil, skip user")
+				continue
+			}
+			err := user.drawBatcher.send(mtype, msg)
+			if err != nil {
+				drawConn.log.warn("write fail", "err", err)
+				continue
+			}
+			drawBytes.add("", float64(len(msg)))
+		}
+	}
+	return true
+}
+
+// handleRoomFrame handles one room message of a user connected from ip, it returns false to close the socket
+func handleRoomFrame(roomId string, currentUserId string, ip string, mtype int, msg []byte) bool {
+	currentRoom, exist := roomStore.Get(roomId)
+	if !exist {
+		return false
+	}
+	reqMessage := &Message{}
+	err := json.Unmarshal(msg, reqMessage)
+	if err != nil {
+		roomLog(roomId, currentUserId).warn("room message is invalid", "err", err)
+		return false
+	}
+	user, exist := currentRoom.Users.Get(currentUserId)
+	if !exist {
+		return false
+	}
+	// the sender is the user of the socket, whatever the message claims
+	reqMessage.UserId = currentUserId
+	reqMessage.UserName = user.UserName
+	messagesIn.inc(reqMessage.Type)
+	if !allowRoomMessage(currentRoom, currentUserId, ip, reqMessage, mtype) {
+		return true
+	}
+
+	if reqMessage.Type == "answer" { // answer question
+		checkAnswer(currentRoom, reqMessage, mtype)
+	} else if reqMessage.Type == "ready" {
+		setReadyFlag(user)
+		var result = checkAllReadyFlag(currentRoom)
+		if result {
+			clearAllReadyFlag(currentRoom)
+			sendNextDrawTopicDetail(currentRoom, mtype)
+		}
+	} else if reqMessage.Type == "startDraw" {
+		clearAllReadyFlag(currentRoom)
+		result := true
+		reqMessage.Result = &result
+		sendReqMessage(reqMessage, currentRoom, mtype)
+	} else {
+		result := true
+		reqMessage.Result = &result
+		sendReqMessage(reqMessage, currentRoom, mtype)
+	}
+	return true
+}
+
+// allowRoomMessage checks the answer and chat limits of the user and of its ip, a rejected message
+// is answered with a rateLimited message carrying its type
+func allowRoomMessage(room *Room, userId string, ip string, reqMessage *Message, mtype int) bool {
+	action := actionChat
+	if reqMessage.Type == "answer" {
+		action = actionAnswer
+	} else if reqMessage.Type == "ready" || reqMessage.Type == "startDraw" {
+		return true
+	}
+	if userRateLimiters[action].allow(userId) && ipRateLimiters[action].allow(ip) {
+		return true
+	}
+	roomLog(room.RoomId, userId).info("rate limited", "action", action, "ip", ip)
+	if user, exist := room.Users.Get(userId); exist {
+		result := false
+		sendReqMessageTo(&Message{"rateLimited", userId, "", room.RoomId, reqMessage.Type, &result}, user, mtype)
+	}
+	return false
+}
+
+// muxWsHandler serves /ws/{roomId}, one socket carrying both draw and room channel
+func muxWsHandler(w http.ResponseWriter, r *http.Request) {
+
+	currentRoomId := strings.TrimPrefix(r.URL.Path, "/ws/")
+	currentUserId := r.URL.Query().Get("userId")
+	_, currentUser, exist := getRoomUser(currentRoomId, currentUserId)
+	if !exist {
+		return
+	}
+	currentIP := clientIP(r)
+	connLog := roomLog(currentRoomId, currentUserId)
+	readLimit := maxDrawFrameBytes
+	if maxRoomFrameBytes > readLimit {
+		readLimit = maxRoomFrameBytes
+	}
+	hc, err := upgradeHubConn(w, r, "muxWsHandler", true, readLimit, connLog)
+	if err != nil {
+		connLog.warn("upgrade fail", "err", err)
+		return
+	}
+	attachDrawConn(currentUser, hc)
+	attachRoomConn(currentUser, hc)
+	hc.log.info("connect")
+
+	defer func() {
+		hc.log.info("disconnect")
+		detachDrawConn(currentUser, hc)
+		detachRoomConn(currentUser, hc)
+		hc.close()
+	}()
+
+	// limit the frames this user can send per second
+	limiter := newTokenBucket(float64(drawMaxFramesPerSecond), float64(drawMaxFramesPerSecond))
+	for {
+		mtype, msg, err := hc.read()
+		if err != nil {
+			hc.log.info("read fail", "err", err)
+			break
+		}
+		if hc.log.enabled(levelDebug) {
+			hc.log.debug("receive", "bytes", len(msg), "payload", msg)
+		}
+
+		if mtype == websocket.BinaryMessage { // binary frames are draw data
+			if hc.frameTooLarge(msg, maxDrawFrameBytes) {
+				break
+			}
+			if !allowDrawFrame(limiter, hc) {
+				continue
+			}
+			if !relayDrawFrame(currentRoomId, currentUserId, mtype, msg) {
+				break
+			}
+			continue
+		}
+
+		envelope := &Envelope{}
+		err = json.Unmarshal(msg, envelope)
+		if err != nil {
+			hc.log.warn("envelope is invalid", "err", err)
+			break
+		}
+		if envelope.Channel == channelDraw {
+			if hc.frameTooLarge(msg, maxDrawFrameBytes) {
+				break
+			}
+			if !allowDrawFrame(limiter, hc) {
+				continue
+			}
+			if !relayDrawFrame(currentRoomId, currentUserId, mtype, unwrapDrawData(envelope.Data)) {
+				break
+			}
+		} else if envelope.Channel == channelRoom {
+			if hc.frameTooLarge(msg, maxRoomFrameBytes) {
+				break
+			}
+			if !handleRoomFrame(currentRoomId, currentUserId, currentIP, mtype, envelope.Data) {
+				break
+			}
+		} else {
+			hc.log.warn("unknown channel", "channel", envelope.Channel)
+		}
+	}
+}
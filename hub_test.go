@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestDrawRelay relays draw frames between a legacy /ws/draw/ socket and a mux /ws/ socket,
+// both must see the stroke the other sent
+func TestDrawRelay(t *testing.T) {
+	legacy, muxConn, closeSockets := openDrawSockets(t)
+	defer closeSockets()
+
+	// legacy to mux, the plain text frame arrives as a json string
+	if err := legacy.WriteMessage(websocket.TextMessage, []byte("1,2,3")); err != nil {
+		t.Fatal(err)
+	}
+	if envelope := readDrawEnvelope(t, muxConn); string(envelope.Data) != `"1,2,3"` {
+		t.Fatalf("mux got %s, want \"1,2,3\"", envelope.Data)
+	}
+
+	// mux to legacy, the json string arrives as the plain text frame
+	for _, c := range []struct{ data, want string }{{`"4,5,6"`, "4,5,6"}, {`{"x":1}`, `{"x":1}`}} {
+		if err := muxConn.WriteMessage(websocket.TextMessage, []byte(`{"channel":"draw","data":`+c.data+`}`)); err != nil {
+			t.Fatal(err)
+		}
+		legacy.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := legacy.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != c.want {
+			t.Fatalf("legacy got %s, want %s", msg, c.want)
+		}
+	}
+}
+
+// TestDrawBatch batches the frames within drawBatchWindow for the mux socket only,
+// the legacy socket gets every frame as sent
+func TestDrawBatch(t *testing.T) {
+	defer func(window time.Duration) { drawBatchWindow = window }(drawBatchWindow)
+	drawBatchWindow = 50 * time.Millisecond
+	legacy, muxConn, closeSockets := openDrawSockets(t)
+	defer closeSockets()
+
+	for _, frame := range []string{`[1,2]`, `[3,4]`} {
+		if err := muxConn.WriteMessage(websocket.TextMessage, []byte(`{"channel":"draw","data":`+frame+`}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{`[1,2]`, `[3,4]`} {
+		legacy.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := legacy.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != want {
+			t.Fatalf("legacy got %s, want %s", msg, want)
+		}
+	}
+
+	for _, frame := range []string{`[5,6]`, `[7,8]`} {
+		if err := legacy.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	envelope := readDrawEnvelope(t, muxConn)
+	if envelope.Channel != channelDrawBatch || string(envelope.Data) != `[[5,6],[7,8]]` {
+		t.Fatalf("mux got %s %s, want drawBatch [[5,6],[7,8]]", envelope.Channel, envelope.Data)
+	}
+}
+
+// openDrawSockets opens a legacy and a mux draw socket of two users in one room,
+// closeSockets waits for their handlers to end
+func openDrawSockets(t *testing.T) (legacy *websocket.Conn, muxConn *websocket.Conn, closeSockets func()) {
+	roomStore = newMemoryRoomStore()
+	room := &Room{RoomId: "r1", RoomName: "room", Users: newRoomUsers(), TopicDetail: &TopicDetail{}}
+	room.Users.Set("legacy", &User{RoomId: "r1", UserId: "legacy", UserName: "Ann"})
+	room.Users.Set("mux", &User{RoomId: "r1", UserId: "mux", UserName: "Ben", DrawOrder: 1})
+	roomStore.Save(room)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/draw/", drawWsHandler)
+	mux.HandleFunc("/ws/", muxWsHandler)
+	server := httptest.NewServer(mux)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	legacy, _, err := websocket.DefaultDialer.Dial(url+"/ws/draw/r1?userId=legacy", nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	muxConn, _, err = websocket.DefaultDialer.Dial(url+"/ws/r1?userId=mux", nil)
+	if err != nil {
+		legacy.Close()
+		server.Close()
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // both sockets attached
+	return legacy, muxConn, func() {
+		legacy.Close()
+		muxConn.Close()
+		waitSocketsClosed(t)
+		server.Close()
+	}
+}
+
+// readDrawEnvelope reads the mux socket until a frame of a draw channel
+func readDrawEnvelope(t *testing.T, muxConn *websocket.Conn) *Envelope {
+	muxConn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, msg, err := muxConn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope := &Envelope{}
+		if err := json.Unmarshal(msg, envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Channel == channelDraw || envelope.Channel == channelDrawBatch {
+			return envelope
+		}
+	}
+}
+
+// waitSocketsClosed waits for the handlers to close their sockets, the next test may
+// change the stores they use
+func waitSocketsClosed(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		liveConns.Lock()
+		open := len(liveConns.conns)
+		liveConns.Unlock()
+		if open == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("sockets are still open")
+}
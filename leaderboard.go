@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	boardAll      = "all"
+	boardWeekly   = "weekly:"   // + iso week, e.g. weekly:2026-W42
+	boardCategory = "category:" // + category of the rounds
+)
+
+// LeaderboardEntry is the rank of a player with a profile, guests are not ranked
+type LeaderboardEntry struct {
+	Rank        int    `json:"rank"`
+	PlayerId    string `json:"playerId"`
+	DisplayName string `json:"displayName"`
+	Score       int    `json:"score"`
+	Games       int    `json:"games"`
+	Wins        int    `json:"wins"`
+}
+
+// LeaderboardPage is one page of a leaderboard, the best first
+type LeaderboardPage struct {
+	Board   string             `json:"board"`
+	Total   int                `json:"total"`
+	Offset  int                `json:"offset"`
+	Limit   int                `json:"limit"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+var (
+	errNotRanked   = &apiError{http.StatusNotFound, "not_ranked", "player is not on this leaderboard"}
+	errInvalidWeek = &apiError{http.StatusBadRequest, "invalid_week", "week must be an iso week, e.g. 2026-W42"}
+)
+
+type boardScore struct {
+	playerId string
+	score    int
+	games    int
+	wins     int
+	index    int // position in leaderboard.ranked
+}
+
+// leaderboard keeps the scores sorted, a score only grows so an update moves it up
+type leaderboard struct {
+	scores map[string]*boardScore
+	ranked []*boardScore
+}
+
+var leaderboardsMutex sync.RWMutex
+var leaderboards = map[string]*leaderboard{}
+
+func (a *boardScore) before(b *boardScore) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if a.wins != b.wins {
+		return a.wins > b.wins
+	}
+	return a.playerId < b.playerId
+}
+
+func (board *leaderboard) add(playerId string, score int, wins int) {
+	entry, exist := board.scores[playerId]
+	if !exist {
+		entry = &boardScore{playerId: playerId, index: len(board.ranked)}
+		board.scores[playerId] = entry
+		board.ranked = append(board.ranked, entry)
+	}
+	entry.score += score
+	entry.games++
+	entry.wins += wins
+	for i := entry.index; i > 0 && entry.before(board.ranked[i-1]); i-- {
+		board.ranked[i], board.ranked[i-1] = board.ranked[i-1], board.ranked[i]
+		board.ranked[i].index = i
+		entry.index = i - 1
+	}
+}
+
+func getLeaderboard(name string) *leaderboard {
+	board, exist := leaderboards[name]
+	if !exist {
+		board = &leaderboard{scores: map[string]*boardScore{}}
+		leaderboards[name] = board
+	}
+	return board
+}
+
+func weekKey(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// addGameToLeaderboards adds the scores of the players with a profile in a finished game
+func addGameToLeaderboards(game *GameRecord) {
+	if game.EndedAt == nil {
+		return
+	}
+	playerIds := map[string]string{} // userId to playerId
+	winners := map[string]bool{}
+	for _, userId := range game.Winners {
+		winners[userId] = true
+	}
+	leaderboardsMutex.Lock()
+	defer leaderboardsMutex.Unlock()
+	weekly := getLeaderboard(boardWeekly + weekKey(*game.EndedAt))
+	for _, player := range game.Players {
+		if player.PlayerId == "" {
+			continue
+		}
+		playerIds[player.UserId] = player.PlayerId
+		wins := 0
+		if winners[player.UserId] {
+			wins = 1
+		}
+		getLeaderboard(boardAll).add(player.PlayerId, player.Score, wins)
+		weekly.add(player.PlayerId, player.Score, wins)
+	}
+	// the points of each category, a player drawing or guessing in it played it once
+	categoryScores := map[string]map[string]int{}
+	for _, round := range game.Rounds {
+		if round.Category == customCategory {
+			continue
+		}
+		scores, exist := categoryScores[round.Category]
+		if !exist {
+			scores = map[string]int{}
+			categoryScores[round.Category] = scores
+		}
+		if playerId, exist := playerIds[round.DrawerId]; exist {
+			scores[playerId] += drawScore * len(round.Guesses)
+		}
+		for _, guess := range round.Guesses {
+			if playerId, exist := playerIds[guess.UserId]; exist {
+				scores[playerId] += guess.Score
+			}
+		}
+	}
+	for category, scores := range categoryScores {
+		board := getLeaderboard(boardCategory + category)
+		for playerId, score := range scores {
+			board.add(playerId, score, 0)
+		}
+	}
+}
+
+// loadLeaderboards builds the leaderboards from every game in the history store
+func loadLeaderboards() {
+	games := 0
+	err := historyStore.Each(func(game *GameRecord) {
+		addGameToLeaderboards(game)
+		games++
+	})
+	if err != nil {
+		appLog.error("leaderboards load fail", "err", err)
+		return
+	}
+	appLog.info("leaderboards load success", "games", games)
+}
+
+func newLeaderboardEntry(entry *boardScore) LeaderboardEntry {
+	displayName := ""
+	if profile, exist := profileStore.Get(entry.playerId); exist {
+		displayName = profile.DisplayName
+	}
+	return LeaderboardEntry{entry.index + 1, entry.playerId, displayName, entry.score, entry.games, entry.wins}
+}
+
+func leaderboardPage(name string, offset int, limit int) *LeaderboardPage {
+	leaderboardsMutex.RLock()
+	defer leaderboardsMutex.RUnlock()
+	page := &LeaderboardPage{Board: name, Offset: offset, Limit: limit, Entries: []LeaderboardEntry{}}
+	board, exist := leaderboards[name]
+	if !exist {
+		return page
+	}
+	page.Total = len(board.ranked)
+	for i := offset; i < len(board.ranked) && i < offset+limit; i++ {
+		page.Entries = append(page.Entries, newLeaderboardEntry(board.ranked[i]))
+	}
+	return page
+}
+
+func leaderboardRank(name string, playerId string) (*LeaderboardEntry, bool) {
+	leaderboardsMutex.RLock()
+	defer leaderboardsMutex.RUnlock()
+	board, exist := leaderboards[name]
+	if !exist {
+		return nil, false
+	}
+	entry, exist := board.scores[playerId]
+	if !exist {
+		return nil, false
+	}
+	leaderboardEntry := newLeaderboardEntry(entry)
+	return &leaderboardEntry, true
+}
+
+// leaderboardRoute passes the board of the route to handler, all or weekly
+func leaderboardRoute(board string, handler func(w http.ResponseWriter, r *http.Request, params map[string]string)) func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		params["board"] = board
+		handler(w, r, params)
+	}
+}
+
+// leaderboardName is the board of the request, the weekly board is this week if week is empty
+func leaderboardName(r *http.Request, params map[string]string) (string, *apiError) {
+	if category, exist := params["category"]; exist {
+		return boardCategory + category, nil
+	}
+	if params["board"] != "weekly" {
+		return boardAll, nil
+	}
+	week := r.URL.Query().Get("week")
+	if week == "" {
+		return boardWeekly + weekKey(time.Now()), nil
+	}
+	var year, number int
+	if n, _ := fmt.Sscanf(week, "%4d-W%2d", &year, &number); n != 2 || number < 1 || number > 53 || weekKey(isoWeekStart(year, number)) != week {
+		return "", errInvalidWeek
+	}
+	return boardWeekly + week, nil
+}
+
+// isoWeekStart is the monday of an iso week
+func isoWeekStart(year int, week int) time.Time {
+	t := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC) // always in week 1
+	t = t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	return t.AddDate(0, 0, (week-1)*7)
+}
+
+func apiLeaderboardHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	name, apiErr := leaderboardName(r, params)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	writeJSON(w, http.StatusOK, leaderboardPage(name, offset, historyQueryLimit(r)))
+}
+
+func apiLeaderboardRankHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	name, apiErr := leaderboardName(r, params)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	entry, exist := leaderboardRank(name, params["playerId"])
+	if !exist {
+		writeAPIError(w, errNotRanked)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
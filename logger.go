@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var logLevel = levelInfo // draw strokes and room message payloads are only logged at debug
+var logOutput io.Writer = os.Stderr
+var logMutex sync.Mutex
+
+// logger writes one json object per line, every line carries the fields of the logger,
+// e.g. roomId, userId and connId of a connection
+type logger struct {
+	fields []interface{} // key, value, key, value...
+}
+
+var appLog = &logger{}
+
+func parseLogLevel(name string) (int, bool) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, true
+		}
+	}
+	return levelInfo, false
+}
+
+// roomLog is the logger of a user in a room, either id may be empty
+func roomLog(roomId string, userId string) *logger {
+	return appLog.with("roomId", roomId, "userId", userId)
+}
+
+// with returns a logger with more fields, keyValues are pairs of a string key and a value
+func (l *logger) with(keyValues ...interface{}) *logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	return &logger{append(fields, keyValues...)}
+}
+
+func (l *logger) enabled(level int) bool {
+	return level >= logLevel
+}
+
+func (l *logger) debug(msg string, keyValues ...interface{}) {
+	l.write(levelDebug, msg, keyValues)
+}
+
+func (l *logger) info(msg string, keyValues ...interface{}) {
+	l.write(levelInfo, msg, keyValues)
+}
+
+func (l *logger) warn(msg string, keyValues ...interface{}) {
+	l.write(levelWarn, msg, keyValues)
+}
+
+func (l *logger) error(msg string, keyValues ...interface{}) {
+	l.write(levelError, msg, keyValues)
+}
+
+// fatal logs at error level and exits
+func (l *logger) fatal(msg string, keyValues ...interface{}) {
+	l.write(levelError, msg, keyValues)
+	os.Exit(1)
+}
+
+func (l *logger) write(level int, msg string, keyValues []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"time":`)
+	writeLogValue(buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeLogValue(buf, levelNames[level])
+	buf.WriteString(`,"msg":`)
+	writeLogValue(buf, msg)
+	writeLogFields(buf, l.fields)
+	writeLogFields(buf, keyValues)
+	buf.WriteString("}\n")
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logOutput.Write(buf.Bytes())
+}
+
+func writeLogFields(buf *bytes.Buffer, keyValues []interface{}) {
+	for i := 0; i < len(keyValues); i += 2 {
+		buf.WriteByte(',')
+		writeLogValue(buf, fmt.Sprint(keyValues[i]))
+		buf.WriteByte(':')
+		if i+1 < len(keyValues) {
+			writeLogValue(buf, keyValues[i+1])
+		} else {
+			buf.WriteString("null")
+		}
+	}
+}
+
+func writeLogValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case []byte:
+		value = string(v)
+	case fmt.Stringer:
+		value = v.String()
+	}
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		jsonBytes, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(jsonBytes)
+}
+
+// stdLogWriter turns the lines of the standard log package, e.g. of net/http, into info lines
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	appLog.info(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -9,32 +10,49 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/gorilla/websocket"
-	cmap "github.com/orcaman/concurrent-map"
 	uuid "github.com/satori/go.uuid"
 )
 
 type Room struct {
-	RoomId           string             `json:"roomId,omitempty"`
-	RoomName         string             `json:"roomName,omitempty"`
-	Users            cmap.ConcurrentMap `json:"users,omitempty"`
-	CurrentDrawOrder int                `json:"currentDrawOrder"`
-	NextDrawOrder    int                `json:"nextDrawOrder"`
-	TopicDetail      *TopicDetail       `json:"topicDetail,omitempty"`
+	RoomId           string       `json:"roomId,omitempty"`
+	RoomName         string       `json:"roomName,omitempty"`
+	Users            *RoomUsers   `json:"users,omitempty"`
+	CurrentDrawOrder int          `json:"currentDrawOrder"`
+	NextDrawOrder    int          `json:"nextDrawOrder"`
+	TopicDetail      *TopicDetail `json:"topicDetail,omitempty"`
+	HostUserId       string       `json:"hostUserId,omitempty"`
+	CustomWords      []string     `json:"-"` // words given by the host, cleared when the room closes
+	CustomWordsMode  string       `json:"customWordsMode,omitempty"`
+	Locale           string       `json:"locale,omitempty"`
+	Difficulty       string       `json:"difficulty,omitempty"`
+	Packs            []string     `json:"packs,omitempty"` // ids of the enabled word packs
+
+	round roundState  // the current topic and its guesses
+	game  *GameRecord // the game in progress, written to the history when finished
+	mutex sync.Mutex  // guards round and game, changed by the socket goroutines while the snapshot reads them
 }
 
 type User struct {
-	RoomId    string          `json:"roomId,omitempty"`
-	UserId    string          `json:"userId,omitempty"`
-	UserName  string          `json:"userName,omitempty"`
-	DrawConn  *websocket.Conn `json:"DrawConn,omitempty"`
-	RoomConn  *websocket.Conn `json:"RoomConn,omitempty"`
-	DrawOrder int             `json:"drawOrder"`
-	Ready     *bool           `json:"ready,omitempty"`
-	Role      string          `json:"role,omitempty"`
+	RoomId    string   `json:"roomId,omitempty"`
+	UserId    string   `json:"userId,omitempty"`
+	UserName  string   `json:"userName,omitempty"`
+	DrawConn  *hubConn `json:"DrawConn,omitempty"`
+	RoomConn  *hubConn `json:"RoomConn,omitempty"`
+	DrawOrder int      `json:"drawOrder"`
+	Ready     *bool    `json:"ready,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	Score     int      `json:"score"`
+	PlayerId  string   `json:"playerId,omitempty"` // profile of the user, empty for a guest
+	UserToken string   `json:"-"`                  // secret of the user issued at join, the userId is public
+
+	drawBatcher *drawBatcher // coalesce draw frames sent to this user
+	pollConn    *hubConn     // room events of a long polling client
+	pollTimer   *time.Timer  // quit the room when long polling stops
 }
 
 type TopicDetail struct {
@@ -55,10 +73,17 @@ type Message struct {
 }
 
 type RoomBean struct {
-	RoomId    string     `json:"roomId,omitempty"`
-	RoomName  string     `json:"roomName,omitempty"`
-	UserBeans []UserBean `json:"users,omitempty"`
-	Result    *bool      `json:"result,omitempty"`
+	RoomId          string     `json:"roomId,omitempty"`
+	RoomName        string     `json:"roomName,omitempty"`
+	UserBeans       []UserBean `json:"users,omitempty"`
+	Result          *bool      `json:"result,omitempty"`
+	CustomWords     []string   `json:"customWords,omitempty"`
+	CustomWordsMode string     `json:"customWordsMode,omitempty"`
+	Locale          string     `json:"locale,omitempty"`
+	Difficulty      string     `json:"difficulty,omitempty"`
+	Packs           []string   `json:"packs,omitempty"`
+	PlayerId        string     `json:"playerId,omitempty"`    // the creating player, limited per player besides the ip
+	PlayerToken     string     `json:"playerToken,omitempty"` // token of the creating player
 }
 
 type UserBean struct {
@@ -66,15 +91,19 @@ type UserBean struct {
 	UserId   string `json:"userId,omitempty"`
 	UserName string `json:"userName,omitempty"`
 	Role     string `json:"role,omitempty"`
+	Score    int    `json:"score"`
 }
 
 type UserJoinRoomBean struct {
-	UserId   string `json:"userId,omitempty"`
-	UserName string `json:"userName,omitempty"`
-	RoomId   string `json:"roomId,omitempty"`
-	RoomName string `json:"roomName,omitempty"`
-	Result   *bool  `json:"result,omitempty"`
-	Role     string `json:"role,omitempty"`
+	UserId      string `json:"userId,omitempty"`
+	UserName    string `json:"userName,omitempty"`
+	RoomId      string `json:"roomId,omitempty"`
+	RoomName    string `json:"roomName,omitempty"`
+	Result      *bool  `json:"result,omitempty"`
+	Role        string `json:"role,omitempty"`
+	PlayerId    string `json:"playerId,omitempty"`    // join with a profile, its displayName if userName is empty
+	PlayerToken string `json:"playerToken,omitempty"` // token of the profile, required with playerId
+	UserToken   string `json:"userToken,omitempty"`   // secret of the user, only in the join response
 }
 
 type Category struct {
@@ -82,163 +111,178 @@ type Category struct {
 }
 
 type Topic struct {
-	Topics []string `json:"topics,omitempty"`
-}
-
-var topics cmap.ConcurrentMap   // store topics
-var roomsMap cmap.ConcurrentMap // store rooms with roomId
-
-func initSafeMap() {
-	topics = cmap.New()
-	roomsMap = cmap.New()
+	Topics []string     `json:"topics,omitempty"`
+	Words  []*TopicWord `json:"words,omitempty"` // topics with locales and aliases
 }
 
-var port = "8899"
+var port = "8899"    // listen port, the address is host:port
+var maxRooms = 0     // rooms open at once, 0 means no limit
+var maxRoomUsers = 0 // users in a room, 0 means no limit
 
 func main() {
 
-	initSafeMap()
+	checkOpenAPI := flag.Bool("check-openapi", false, "check public/openapi.json against the go types and exit")
+	importFile := flag.String("import", "", "import a csv or json word pack into the topic files and exit")
+	exportFile := flag.String("export", "", "export the topics to a csv or json file (- for stdout) and exit")
+	format := flag.String("format", "", "csv or json, by default from the file extension")
+	dryRun := flag.Bool("dry-run", false, "with -import, only validate and report duplicates")
+	showConfig := flag.Bool("print-config", false, "print the settings after the config file, the environment and the flags, and exit")
+	registerConfigFlags()
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	if failures := loadConfig(); len(failures) > 0 {
+		for _, failure := range failures {
+			appLog.error("invalid setting", "setting", failure)
+		}
+		appLog.fatal("config fail", "invalid", len(failures))
+	}
+	if *showConfig {
+		if err := printConfig(); err != nil {
+			appLog.fatal("print config fail", "err", err)
+		}
+		return
+	}
+	if *checkOpenAPI {
+		if err := validateOpenAPISpec(); err != nil {
+			appLog.fatal("openapi spec fail", "err", err)
+		}
+		appLog.info("openapi spec ok")
+		return
+	}
+	if err := validateOpenAPISpec(); err != nil {
+		appLog.warn("openapi spec fail", "err", err)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	if err := openStores(); err != nil {
+		appLog.fatal("store open fail", "err", err)
+	}
+	if *importFile != "" || *exportFile != "" {
+		if err := runTopicCommand(*importFile, *exportFile, *format, *dryRun); err != nil {
+			appLog.fatal("topic command fail", "err", err)
+		}
+		return
+	}
 	loading()
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/topic/", topicHandler)
+	loadLeaderboards()
+	restoreRooms()
+	go watchTopics()
+	go watchRoomSnapshot()
+	http.HandleFunc("/", instrumentHandler("/", homeHandler))
+	http.HandleFunc("/topic/", instrumentHandler("/topic/", topicHandler))
 	http.HandleFunc("/ws/draw/", drawWsHandler)
 	http.HandleFunc("/ws/room/", roomWsHandler)
-	http.HandleFunc("/room/", roomHandler) // create, list room .etc
-	http.HandleFunc("/.well-known/assetlinks.json", appLinkHandler)
+	http.HandleFunc("/ws/", muxWsHandler)                                    // draw and room channel in one socket
+	http.HandleFunc("/room/", instrumentHandler("/room/", roomHandler))      // create, list room .etc
+	http.HandleFunc("/api/v1/", instrumentHandler("/api/v1/", apiV1Handler)) // rest api, /room/* and /topic/* are its aliases
+	http.HandleFunc("/sse/room/", sseRoomHandler)                            // room events for clients without websocket
+	http.HandleFunc("/poll/room/", pollRoomHandler)                          // room events by long polling
+	http.HandleFunc("/.well-known/assetlinks.json", instrumentHandler("/.well-known/assetlinks.json", appLinkHandler))
+	http.HandleFunc("/openapi.json", instrumentHandler("/openapi.json", openAPIHandler))
+	http.HandleFunc("/history", instrumentHandler("/history", historyHandler))
+	http.HandleFunc("/history/", instrumentHandler("/history/", historyHandler)) // alias of /api/v1/history/*
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/debug/state", instrumentHandler("/debug/state", debugStateHandler))
+	http.HandleFunc("/metrics", metricsHandler) // prometheus text format
 	// http.Handle("/public/", http.FileServer(http.Dir("./public/picture/")))
-	log.Println("server start at :8899")
-
-	if v := os.Getenv("PORT"); len(v) > 0 {
-		port = v
+	server := &http.Server{Addr: host + ":" + port}
+	done := make(chan int)
+	go watchShutdown(server, done)
+	appLog.info("server start", "addr", server.Addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		appLog.fatal("server stop", "err", err)
 	}
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	os.Exit(<-done)
 
 }
 
 func loading() {
-	category := &Category{}
-	err := getSampleTopicFile("config.json", category)
-	if err != nil {
-		log.Println("category config not exist!!")
+	reloadPacks()
+	report := loadTopics()
+	if !report.Loaded {
+		appLog.error("topics load fail")
 		return
 	}
-	for _, category := range category.Category {
-		topic := &Topic{}
-		err = getSampleTopicFile(category+".json", topic)
-		if err != nil {
-			log.Println("category: " + category + "not exist!!")
-			return
-		}
-		topics.Set(category, topic)
-	}
-	log.Println("topics load success!!")
-}
-
-func randomTopic() (category string, topic string) {
-	tmpCategory := ""
-	tmpTopic := ""
-	for item := range topics.Iter() { // get category
-		tmpCategory = item.Key
-		break
-	}
-
-	topicsInterface, exist := topics.Get(tmpCategory)
-	if exist {
-		topic := topicsInterface.(*Topic)
-		rand.Seed(time.Now().Unix())
-		tmpTopic = topic.Topics[rand.Intn(len(topic.Topics))]
-	}
-
-	return tmpCategory, tmpTopic
+	appLog.info("topics load success", "categories", report.Categories, "topics", report.Topics,
+		"failedFiles", len(report.Failures))
 }
 
 func topicHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	lang := r.URL.Query().Get("lang")
 	if r.URL.Path == "/topic/list" {
-		jsonString, err := json.Marshal(topics)
+		var jsonString []byte
+		var err error
+		if lang == "" {
+			jsonString, err = json.Marshal(topicStore.All())
+		} else {
+			jsonString, err = json.Marshal(localeTopics(lang))
+		}
+		if err != nil {
+			return
+		}
+		fmt.Fprint(w, string(jsonString))
+	} else if r.URL.Path == "/topic/packs" {
+		jsonString, err := json.Marshal(listPackBeans(lang))
 		if err != nil {
 			return
 		}
 		fmt.Fprint(w, string(jsonString))
 	} else if r.URL.Path == "/topic/random" {
-		category, topic := randomTopic()
-		text := `{"category":"` + category + `","` + `topic":"` + topic + `"}`
-		fmt.Fprint(w, text)
+		picked := randomTopic(requestLocale(lang), r.URL.Query().Get("difficulty"))
+		if picked == nil { // the old clients read both keys, empty if no topic
+			writeJSON(w, http.StatusOK, map[string]string{"category": "", "topic": ""})
+			return
+		}
+		writeJSON(w, http.StatusOK, TopicDetail{Category: picked.Category, Topic: picked.Topic})
+	} else {
+		writeAPIError(w, errNotFound)
 	}
 
 }
 
 func drawWsHandler(w http.ResponseWriter, r *http.Request) {
 
-	currentRoomId := strings.Split(r.URL.Path, "/ws/draw/")[1] // get room id
-	currentUserId := r.URL.Query().Get("userId")               // get user id
-	if currentUserId == "" {                                   // check userId empty
-		return
-	}
-
-	currentRoomInterface, roomExist := roomsMap.Get(currentRoomId) // check room exist , get room
-	if roomExist == false {
-		return
-	}
-	currentRoom := currentRoomInterface.(*Room)                             // interface{} to room
-	currentUserInterface, userExist := currentRoom.Users.Get(currentUserId) // check user is login
-	if userExist == false {
+	currentRoomId := strings.Split(r.URL.Path, "/ws/draw/")[1]         // get room id
+	currentUserId := r.URL.Query().Get("userId")                       // get user id
+	_, currentUser, exist := getRoomUser(currentRoomId, currentUserId) // check room exist and user is login
+	if !exist {
 		return
 	}
-	currentUser := currentUserInterface.(*User)
-	upgrader := &websocket.Upgrader{
-
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	conn, err := upgrader.Upgrade(w, r, nil) // get *conn
-	conn.SetPingHandler(func(s string) error {
-		log.Println("drawWsHandler get ping!!!")
-		conn.WriteMessage(websocket.PongMessage, []byte("pong"))
-		return nil
-	})
-	currentUser.DrawConn = conn
-	log.Println("drawWsHandler connect !!")
-
+	connLog := roomLog(currentRoomId, currentUserId)
+	hc, err := upgradeHubConn(w, r, "drawWsHandler", false, maxDrawFrameBytes, connLog)
 	if err != nil {
-		log.Println("upgrade:", err)
+		connLog.warn("upgrade fail", "err", err)
 		return
 	}
+	attachDrawConn(currentUser, hc)
+	hc.log.info("connect")
 
 	defer func() {
-		log.Println("drawWsHandler disconnect !!")
-		currentUser.DrawConn = nil
-		conn.Close()
+		hc.log.info("disconnect")
+		detachDrawConn(currentUser, hc)
+		hc.close()
 	}()
 
+	// limit the frames this user can send per second
+	limiter := newTokenBucket(float64(drawMaxFramesPerSecond), float64(drawMaxFramesPerSecond))
 	for {
-		mtype, msg, err := conn.ReadMessage()
+		mtype, msg, err := hc.read()
 		if err != nil {
-			log.Println("read:", err)
+			hc.log.info("read fail", "err", err)
 			break
 		}
-		log.Printf("receive: %s\n", msg)
-
-		currentRoomInterface, exist := roomsMap.Get(currentRoomId) // get current room
-		if exist == false {
-			return
+		if hc.log.enabled(levelDebug) {
+			hc.log.debug("receive", "bytes", len(msg), "payload", msg)
 		}
-		currentRoom := currentRoomInterface.(*Room)
-		roomUsers := currentRoom.Users // get the users in this room
-		for item := range roomUsers.Iter() {
-			userInterface := item.Val
-			user := userInterface.(*User)
-			if user.UserId != currentUserId { // do not send msg to (s)hseself
-				if user.DrawConn == nil {
-					log.Println("user DrawConn is nil!!")
-					break
-				}
-				err = user.DrawConn.WriteMessage(mtype, msg)
-				if err != nil {
-					log.Println("write:", err)
-					break
-				}
-			}
+		if !allowDrawFrame(limiter, hc) {
+			continue
+		}
+		if !relayDrawFrame(currentRoomId, currentUserId, mtype, msg) {
+			return
 		}
 	}
 }
@@ -247,125 +291,50 @@ func roomWsHandler(w http.ResponseWriter, r *http.Request) {
 
 	currentRoomId := strings.Split(r.URL.Path, "/ws/room/")[1]
 	currentUserId := r.URL.Query().Get("userId")
-	if currentUserId == "" {
-		return
-	}
-
-	currentRoomInterface, roomExist := roomsMap.Get(currentRoomId)
-	if roomExist == false {
-		return
-	}
-	currentRoom := currentRoomInterface.(*Room)
-	currentUserInterface, userExist := currentRoom.Users.Get(currentUserId) // check user is login
-	if userExist == false {
+	_, currentUser, exist := getRoomUser(currentRoomId, currentUserId)
+	if !exist {
 		return
 	}
-	currentUser := currentUserInterface.(*User)
-	result := true
-	currentUser.Ready = &result
-	upgrader := &websocket.Upgrader{
-
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	conn, err := upgrader.Upgrade(w, r, nil) // get *conn
+	currentIP := clientIP(r)
+	connLog := roomLog(currentRoomId, currentUserId)
+	hc, err := upgradeHubConn(w, r, "roomWsHandler", false, maxRoomFrameBytes, connLog)
 	if err != nil {
-		log.Println("upgrade:", err)
+		connLog.warn("upgrade fail", "err", err)
 		return
 	}
-	conn.SetPingHandler(func(s string) error {
-		log.Println("roomWsHandler get ping!!!")
-		conn.WriteMessage(websocket.PongMessage, []byte("pong"))
-		return nil
-	})
-
-	currentUser.RoomConn = conn
-	log.Println("roomWsHandler connect !!")
-
-	// send others you join
-	sendAction(currentUser, "join")
+	attachRoomConn(currentUser, hc)
+	hc.log.info("connect")
 
 	defer func() {
-		log.Println("roomWsHandler disconnect !!")
-		currentUser.RoomConn = nil
-		// send others you quit
-		sendAction(currentUser, "quit")
-		// user quit room
-		roomInterface, roomExist := roomsMap.Get(currentRoomId)
-		if roomExist {
-			room := roomInterface.(*Room)
-			// adjust drawOrder
-			adjustDrawOrder(room, currentUser.DrawOrder)
-			if room.TopicDetail != nil {
-				if room.TopicDetail.CurrentDrawUserId == currentUserId {
-					room.TopicDetail.NextDrawUserId = getNextDrawOrderUserId(room)
-				}
-			}
-			//remove user form room's user map
-			room.Users.Remove(currentUserId)
-			if room.Users.Count() == 0 {
-				roomsMap.Remove(currentRoomId)
-			}
-		}
-		conn.Close()
+		hc.log.info("disconnect")
+		detachRoomConn(currentUser, hc)
+		hc.close()
 	}()
 
 	for {
 
-		mtype, msg, err := conn.ReadMessage()
+		mtype, msg, err := hc.read()
 		if err != nil {
-			log.Println("read:", err)
+			hc.log.info("read fail", "err", err)
 			break
 		}
-		log.Printf("receive: %s\n", msg)
-
-		currentRoomInterface, exist := roomsMap.Get(currentRoomId)
-		if exist == false {
-			break
+		if hc.log.enabled(levelDebug) {
+			hc.log.debug("receive", "bytes", len(msg), "payload", msg)
 		}
-		reqMessage := &Message{}
-		err = json.Unmarshal(msg, reqMessage)
-		if err != nil {
-			println(err)
+
+		if !handleRoomFrame(currentRoomId, currentUserId, currentIP, mtype, msg) {
 			break
 		}
-		currentRoom := currentRoomInterface.(*Room)
-
-		if reqMessage.Type == "answer" { // answer question
-			checkAnswer(currentRoom, reqMessage, mtype)
-		} else if reqMessage.Type == "ready" {
-			roomUsers := currentRoom.Users
-			userInterface, exist := roomUsers.Get(currentUserId)
-			if !exist {
-				return
-			}
-			user := userInterface.(*User)
-			setReadyFlag(user)
-			var result = checkAllReadyFlag(currentRoom)
-			if result {
-				clearAllReadyFlag(currentRoom)
-				sendNextDrawTopicDetail(currentRoom, mtype)
-			}
-		} else if reqMessage.Type == "startDraw" {
-			clearAllReadyFlag(currentRoom)
-			result := true
-			reqMessage.Result = &result
-			sendReqMessage(reqMessage, currentRoom, mtype)
-		} else {
-			result := true
-			reqMessage.Result = &result
-			sendReqMessage(reqMessage, currentRoom, mtype)
-		}
 	}
 }
 
 func sendNextDrawTopicDetail(room *Room, mtype int) {
 	userId := room.TopicDetail.NextDrawUserId // get the next draw userId in this room
 	roomUsers := room.Users
-	userInterface, exist := roomUsers.Get(userId)
+	user, exist := roomUsers.Get(userId)
 	if !exist {
 		return //----
 	}
-	user := userInterface.(*User)
 	result := true
 	reqMessage := &Message{"nextDraw", userId, user.UserName, room.RoomId, "", &result}
 	sendReqMessageTo(reqMessage, user, mtype)
@@ -373,21 +342,20 @@ func sendNextDrawTopicDetail(room *Room, mtype int) {
 
 func sendReqMessageTo(reqMessage *Message, user *User, mtype int) {
 
-	if user.RoomConn != nil {
+	if roomConn := user.RoomConn; roomConn != nil {
 		respMsg, err := json.Marshal(reqMessage)
-		err = user.RoomConn.WriteMessage(mtype, respMsg)
+		err = roomConn.write(channelRoom, mtype, respMsg)
 		if err != nil {
-			log.Println("write:", err)
+			roomConn.log.warn("write fail", "err", err)
 			return
 		}
+		messagesOut.inc(reqMessage.Type)
 	}
 }
 
 func sendReqMessage(reqMessage *Message, room *Room, mtype int) {
 	roomUsers := room.Users // get the users in this room
-	for item := range roomUsers.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range roomUsers.List() {
 
 		sendReqMessageTo(reqMessage, user, mtype)
 	}
@@ -395,10 +363,17 @@ func sendReqMessage(reqMessage *Message, room *Room, mtype int) {
 
 func checkAnswer(room *Room, reqMessage *Message, mtype int) {
 
-	currentTopic := room.TopicDetail.Topic
-	result := false
-	if currentTopic == reqMessage.Message {
-		result = true
+	room.mutex.Lock()
+	result := matchAnswer(room, reqMessage.Message)
+	scored := result && room.TopicDetail != nil && reqMessage.UserId != room.TopicDetail.CurrentDrawUserId &&
+		scoreCorrectGuess(room, reqMessage.UserId)
+	if scored {
+		recordCorrectGuess(room)
+	}
+	room.mutex.Unlock()
+	recordAnswerCheck(result)
+	if scored {
+		saveRoom(room)
 	}
 	reqMessage.Result = &result
 	sendReqMessage(reqMessage, room, mtype)
@@ -413,9 +388,7 @@ func setReadyFlag(user *User) {
 func checkAllReadyFlag(room *Room) bool {
 
 	roomUsers := room.Users // get the users in this room
-	for item := range roomUsers.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range roomUsers.List() {
 		if *(user.Ready) == false {
 			return false
 		}
@@ -426,44 +399,38 @@ func checkAllReadyFlag(room *Room) bool {
 func clearAllReadyFlag(room *Room) {
 	flag := false
 	roomUsers := room.Users // get the users in this room
-	for item := range roomUsers.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range roomUsers.List() {
 
 		user.Ready = &flag
 	}
 }
 
 func sendAction(currentUser *User, action string) {
-	currentRoomInterface, exist := roomsMap.Get(currentUser.RoomId)
+	currentRoom, exist := roomStore.Get(currentUser.RoomId)
 	if exist == false {
 		return
 	}
-	currentRoom := currentRoomInterface.(*Room)
 	roomUsers := currentRoom.Users // get the users in this room
-	for item := range roomUsers.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range roomUsers.List() {
 		// if user.UserId != currentUserId { // do not send msg to (s)hseself
-		if user.RoomConn != nil {
+		if roomConn := user.RoomConn; roomConn != nil {
 
 			result := false
 			reqMessage := &Message{action, currentUser.UserId, currentUser.UserName, currentUser.RoomId, "", &result}
 			respMsg, err := json.Marshal(reqMessage)
-			err = user.RoomConn.WriteMessage(1, respMsg)
+			err = roomConn.write(channelRoom, websocket.TextMessage, respMsg)
 			if err != nil {
-				log.Println("write:", err)
+				roomConn.log.warn("write fail", "err", err)
 				return
 			}
+			messagesOut.inc(action)
 			// }
 		}
 	}
 }
 
 func adjustDrawOrder(room *Room, quitUserDrawOrder int) {
-	for item := range room.Users.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range room.Users.List() {
 		if user.DrawOrder > quitUserDrawOrder {
 			user.DrawOrder -= 1
 		}
@@ -473,9 +440,9 @@ func adjustDrawOrder(room *Room, quitUserDrawOrder int) {
 func appLinkHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	file, err := os.Open(".well-known/assetlinks.json")
+	file, err := os.Open(assetLinksFile)
 	if err != nil {
-		log.Println(err)
+		appLog.warn("asset links fail", "err", err)
 		return
 	}
 	defer file.Close()
@@ -491,11 +458,11 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, err := template.ParseFiles("./template/html/home.html")
+	t, err := template.ParseFiles(homeTemplate)
 	if t != nil {
 		t.Execute(w, nil)
 	} else {
-		println(err.Error())
+		appLog.error("home template fail", "err", err)
 	}
 }
 
@@ -506,112 +473,63 @@ func errorHandler(w http.ResponseWriter, r *http.Request, status int) {
 	}
 }
 
+// legacy room paths, kept as aliases of the /api/v1 routes for the released apks
+var roomRoutes = map[string]http.HandlerFunc{
+	"/room/list":      roomListHandler,
+	"/room/users":     roomUsersHandler,
+	"/room/create":    roomCreateHandler,
+	"/room/join":      roomJoinHandler,
+	"/room/quit":      roomQuitHandler,
+	"/room/startDraw": roomStartGameHandler,
+	"/room/topic":     roomTopicHandler,
+	"/room/cleanAll":  roomCleanAllHandler,
+	"/room/listAll":   roomListAllHandler,
+}
+
 func roomHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if r.URL.Path == "/room/list" {
-		roomListHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/users" {
-		roomUsersHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/create" {
-		roomCreateHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/join" {
-		roomJoinHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/quit" {
-		roomQuitHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/startDraw" {
-		roomStartGameHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/topic" {
-		roomTopicHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/cleanAll" {
-		roomCleanAllHandler(w, r)
-		return
-	} else if r.URL.Path == "/room/listAll" {
-		roomListAllHandler(w, r)
+	handler, exist := roomRoutes[r.URL.Path]
+	if !exist {
+		writeAPIError(w, errNotFound)
 		return
 	}
-
+	handler(w, r)
 }
-func roomListAllHandler(w http.ResponseWriter, r *http.Request) {
 
-	jsonBytes, err := json.Marshal(roomsMap)
-	if err != nil {
-		println(err)
+// roomListAllHandler and roomCleanAllHandler are the aliases of the admin routes, they need the admin token too
+func roomListAllHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAdmin(w, r) {
 		return
 	}
-	fmt.Fprint(w, string(jsonBytes))
+	writeJSON(w, http.StatusOK, allRooms())
 }
+
 func roomCleanAllHandler(w http.ResponseWriter, r *http.Request) {
-	roomsMap = cmap.New()
+	if !checkAdmin(w, r) {
+		return
+	}
+	cleanAllRooms()
 	fmt.Fprint(w, "room Clean all!!")
 }
 
 func roomUsersHandler(w http.ResponseWriter, r *http.Request) {
-	roomId := r.URL.Query().Get("roomId")
-	result := true
-	roomInterface, roomExist := roomsMap.Get(roomId)
-	if roomId == "" || !roomExist {
-		result = false
-	}
-	room := roomInterface.(*Room)
-	roomBean := RoomBean{"", "", nil, &result}
-	if result {
-		roomBean.RoomId = room.RoomId
-		roomBean.RoomName = room.RoomName
-		for item := range room.Users.Iter() {
-			userInterface := item.Val
-			user := userInterface.(*User)
-			userBean := UserBean{user.RoomId, user.UserId, user.UserName, user.Role}
-			roomBean.UserBeans = append(roomBean.UserBeans, userBean)
-		}
-	}
-	jsonBytes, err := json.Marshal(roomBean)
-	if err != nil {
-		println(err)
-		return
+	roomBean, apiErr := getRoomBean(r.URL.Query().Get("roomId"))
+	if apiErr != nil {
+		result := false
+		roomBean = &RoomBean{Result: &result}
 	}
-	fmt.Fprint(w, string(jsonBytes))
+	writeJSON(w, http.StatusOK, roomBean)
 }
 
 func roomStartGameHandler(w http.ResponseWriter, r *http.Request) {
-	category, topic := randomTopic()
-	roomId := r.URL.Query().Get("roomId")
-	roomInterface, roomExist := roomsMap.Get(roomId)
-	result := true
-	if roomId == "" || !roomExist {
-		result = false
-	}
-	topicDetail := &TopicDetail{"", "", "", "", &result}
-	if result {
-		room := roomInterface.(*Room)
-		if room.Users.Count() == 0 {
-			result = false
-		} else {
-			userId := userToDrawDispatcher(room)
-			topicDetail.Category = category
-			topicDetail.Topic = topic
-			topicDetail.CurrentDrawUserId = userId
-			topicDetail.NextDrawUserId = getNextDrawOrderUserId(room)
-		}
-
-		topicDetail.Result = &result
-		room.TopicDetail = topicDetail
+	topicDetail, apiErr := startDraw(r.URL.Query().Get("roomId"))
+	if apiErr != nil {
+		result := false
+		topicDetail = &TopicDetail{"", "", "", "", &result}
 	}
-
-	jsonBytes, err := json.Marshal(topicDetail)
-	if err != nil {
-		println(err)
-		return
-	}
-	fmt.Fprint(w, string(jsonBytes))
-
+	writeJSON(w, http.StatusOK, topicDetail)
 }
+
 func userToDrawDispatcher(room *Room) string {
 
 	room.NextDrawOrder = room.CurrentDrawOrder + 1
@@ -623,9 +541,7 @@ func userToDrawDispatcher(room *Room) string {
 	room.CurrentDrawOrder = room.NextDrawOrder
 	targetUserId := ""
 	roomUsers := room.Users
-	for item := range roomUsers.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range roomUsers.List() {
 		if user.DrawOrder == room.CurrentDrawOrder {
 			targetUserId = user.UserId
 			room.TopicDetail.CurrentDrawUserId = targetUserId
@@ -643,9 +559,7 @@ func getNextDrawOrderUserId(room *Room) string {
 		return ""
 	}
 	room.NextDrawOrder %= roomUsers.Count() // next draw order
-	for item := range roomUsers.Iter() {
-		userInterface := item.Val
-		user := userInterface.(*User)
+	for _, user := range roomUsers.List() {
 		if user.DrawOrder == room.NextDrawOrder {
 			targetUserId = user.UserId
 			room.TopicDetail.NextDrawUserId = targetUserId
@@ -655,173 +569,313 @@ func getNextDrawOrderUserId(room *Room) string {
 }
 
 func roomTopicHandler(w http.ResponseWriter, r *http.Request) {
-	roomId := r.URL.Query().Get("roomId")
-	result := true
-	roomInterface, roomExist := roomsMap.Get(roomId)
-	if roomExist == false {
-		result = false
-
-	}
-	topicDetail := &TopicDetail{"", "", "", "", &result}
-
-	if result {
-		room := roomInterface.(*Room)
-		if room.TopicDetail != nil && room.Users.Count() > 0 {
-			topicDetail = room.TopicDetail
-		} else {
-			result = false
-		}
-	}
-	topicDetail.Result = &result
-	jsonBytes, err := json.Marshal(topicDetail)
-	if err != nil {
-		println(err)
-		return
+	topicDetail, apiErr := getRoomTopicDetail(r.URL.Query().Get("roomId"))
+	if apiErr != nil {
+		result := false
+		topicDetail = &TopicDetail{"", "", "", "", &result}
 	}
-	fmt.Fprint(w, string(jsonBytes))
-
+	writeJSON(w, http.StatusOK, topicDetail)
 }
 
 func roomListHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, listRoomBeans())
+}
 
-	roomBeans := make([]RoomBean, 0, roomsMap.Count())
-	for item := range roomsMap.Iter() {
-		roomInterface := item.Val
-		room := roomInterface.(*Room)
-		userBeans := make([]UserBean, 0, room.Users.Count())
-		for item := range room.Users.Iter() {
-			userInterface := item.Val
-			user := userInterface.(*User)
-			userBean := UserBean{user.RoomId, user.UserId, user.UserName, user.Role}
-			userBeans = append(userBeans, userBean)
-		}
-		roomBean := RoomBean{room.RoomId, room.RoomName, userBeans, nil}
-		roomBeans = append(roomBeans, roomBean)
+func roomCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRate(actionRoomCreate, w, r) {
+		return
 	}
 
-	jsonBytes, err := json.Marshal(roomBeans)
+	roomBean := &RoomBean{}
+	err := json.NewDecoder(r.Body).Decode(roomBean)
 	if err != nil {
-		println(err)
+		appLog.debug("room bean is invalid", "err", err)
+		writeAPIError(w, errBadRequest)
 		return
 	}
-	fmt.Fprint(w, string(jsonBytes))
+	if !checkPlayerRate(actionRoomCreate, roomBean.PlayerId, roomBean.PlayerToken, w) {
+		return
+	}
+	respRoomBean, apiErr := createRoom(roomBean)
+	if apiErr != nil {
+		result := false
+		respRoomBean = &RoomBean{RoomName: roomBean.RoomName, Result: &result}
+	}
+	writeJSON(w, http.StatusOK, respRoomBean)
 }
 
-func roomCreateHandler(w http.ResponseWriter, r *http.Request) {
+func roomJoinHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRate(actionRoomJoin, w, r) {
+		return
+	}
 
-	roomBean := &RoomBean{}
-	result := true
-	err := json.NewDecoder(r.Body).Decode(roomBean)
+	userJoinRoomBean := &UserJoinRoomBean{}
+	err := json.NewDecoder(r.Body).Decode(userJoinRoomBean)
 	if err != nil {
-		println(err)
+		appLog.debug("join bean is invalid", "err", err)
+		writeAPIError(w, errBadRequest)
 		return
 	}
-	roomName := roomBean.RoomName
-	if roomName == "" {
-		result = false
-		println("roomName is empty!!")
+	if !checkPlayerRate(actionRoomJoin, userJoinRoomBean.PlayerId, userJoinRoomBean.PlayerToken, w) {
+		return
 	}
+	joinRoom(userJoinRoomBean)
+	writeJSON(w, http.StatusOK, userJoinRoomBean)
+}
 
-	respRoomBean := &RoomBean{"", roomName, nil, &result}
-	if result {
-		roomId := generateRoomId()
-		respRoomBean.RoomId = roomId
-		room := &Room{roomId, roomName, cmap.New(), -1, 0, &TopicDetail{}}
-		roomsMap.Set(roomId, room)
+func roomQuitHandler(w http.ResponseWriter, r *http.Request) {
+	userJoinRoomBean, apiErr := quitRoom(r.URL.Query().Get("roomId"), r.URL.Query().Get("userId"))
+	if apiErr != nil {
+		result := false
+		userJoinRoomBean = &UserJoinRoomBean{"", "", "", "", &result, "", "", "", ""}
 	}
+	writeJSON(w, http.StatusOK, userJoinRoomBean)
+}
 
-	jsonBytes, err := json.Marshal(respRoomBean)
-	if err != nil {
-		println(err)
-		return
+func listRoomBeans() []RoomBean {
+	roomBeans := make([]RoomBean, 0, roomStore.Count())
+	for _, room := range roomStore.List() {
+		roomBean := RoomBean{RoomId: room.RoomId, RoomName: room.RoomName, UserBeans: getUserBeans(room)}
+		roomBeans = append(roomBeans, roomBean)
 	}
-	fmt.Fprintln(w, string(jsonBytes))
+	return roomBeans
 }
 
-func roomJoinHandler(w http.ResponseWriter, r *http.Request) {
+func getUserBeans(room *Room) []UserBean {
+	userBeans := make([]UserBean, 0, room.Users.Count())
+	for _, user := range room.Users.List() {
+		userBean := UserBean{user.RoomId, user.UserId, user.UserName, user.Role, user.Score}
+		userBeans = append(userBeans, userBean)
+	}
+	return userBeans
+}
 
-	userJoinRoomBean := &UserJoinRoomBean{}
-	err := json.NewDecoder(r.Body).Decode(userJoinRoomBean)
-	if err != nil {
-		println(err)
-		return
+func getRoomBean(roomId string) (*RoomBean, *apiError) {
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return nil, errRoomNotFound
 	}
 	result := true
+	return &RoomBean{RoomId: room.RoomId, RoomName: room.RoomName, UserBeans: getUserBeans(room), Result: &result,
+		CustomWordsMode: room.CustomWordsMode, Locale: room.Locale, Difficulty: room.Difficulty, Packs: room.Packs}, nil
+}
 
-	roomInterface, roomExist := roomsMap.Get(userJoinRoomBean.RoomId)
-	if roomExist == false {
-		result = false
+func createRoom(roomBean *RoomBean) (*RoomBean, *apiError) {
+	if isShuttingDown() {
+		return nil, errShuttingDown
 	}
-	if userJoinRoomBean.UserName == "" {
-		result = false
+	roomName := roomBean.RoomName
+	if roomName == "" {
+		appLog.debug("roomName is empty")
+		return nil, errInvalidRoomName
 	}
-	userJoinRoomBean.Result = &result
-	if result {
-		room := roomInterface.(*Room)
-		userJoinRoomBean.UserId = generateUserId()
-		userJoinRoomBean.RoomName = room.RoomName
-		tmpUser := &User{userJoinRoomBean.RoomId, userJoinRoomBean.UserId,
-			userJoinRoomBean.UserName, nil, nil, room.Users.Count(), &result, userJoinRoomBean.Role}
-		room.Users.Set(userJoinRoomBean.UserId, tmpUser)
+	if maxRooms > 0 && roomStore.Count() >= maxRooms {
+		return nil, errTooManyRooms
+	}
+	customWords, customWordsMode, apiErr := checkCustomWords(roomBean.CustomWords, roomBean.CustomWordsMode)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	locale := roomBean.Locale
+	if locale == "" {
+		locale = defaultLocale
+	} else if !validLocale(locale) {
+		return nil, errInvalidLocale
 	}
+	difficulty := roomBean.Difficulty
+	if difficulty == "" {
+		difficulty = difficultyMixed
+	} else if !validRoomDifficulty(difficulty) {
+		return nil, errInvalidDifficulty
+	}
+	packs, apiErr := checkPacks(roomBean.Packs)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	result := true
+	roomId := generateRoomId()
+	room := &Room{RoomId: roomId, RoomName: roomName, Users: newRoomUsers(), CurrentDrawOrder: -1, NextDrawOrder: 0,
+		TopicDetail: &TopicDetail{}, CustomWords: customWords, CustomWordsMode: customWordsMode, Locale: locale, Difficulty: difficulty,
+		Packs: packs}
+	saveRoom(room)
+	return &RoomBean{RoomId: roomId, RoomName: roomName, Result: &result, CustomWordsMode: customWordsMode,
+		Locale: locale, Difficulty: difficulty, Packs: packs}, nil
+}
 
-	jsonBytes, err := json.Marshal(userJoinRoomBean)
-	if err != nil {
-		println(err)
-		return
+// joinRoom fills userId, roomName and result of userJoinRoomBean
+func joinRoom(userJoinRoomBean *UserJoinRoomBean) (apiErr *apiError) {
+	defer func() {
+		if apiErr != nil {
+			joinFailures.inc(apiErr.Code)
+		}
+	}()
+	result := false
+	userJoinRoomBean.Result = &result
+	playerToken := userJoinRoomBean.PlayerToken
+	userJoinRoomBean.PlayerToken = "" // not written back
+	if isShuttingDown() {
+		return errShuttingDown
+	}
+	room, roomExist := roomStore.Get(userJoinRoomBean.RoomId)
+	if !roomExist {
+		return errRoomNotFound
+	}
+	if userJoinRoomBean.PlayerId != "" {
+		profile, exist := profileStore.Get(userJoinRoomBean.PlayerId)
+		if !exist {
+			return errPlayerNotFound
+		}
+		if !checkPlayerToken(profile, playerToken) {
+			return errPlayerToken
+		}
+		if userJoinRoomBean.UserName == "" {
+			userJoinRoomBean.UserName = profile.DisplayName
+		}
 	}
-	fmt.Fprintln(w, string(jsonBytes))
+	if userJoinRoomBean.UserName == "" {
+		return errInvalidUserName
+	}
+	if maxRoomUsers > 0 && room.Users.Count() >= maxRoomUsers {
+		return errRoomFull
+	}
+	result = true
+	userJoinRoomBean.UserId = generateUserId()
+	userJoinRoomBean.UserToken = generateUserToken()
+	userJoinRoomBean.RoomName = room.RoomName
+	tmpUser := &User{RoomId: userJoinRoomBean.RoomId, UserId: userJoinRoomBean.UserId,
+		UserName: userJoinRoomBean.UserName, DrawOrder: room.Users.Count(), Ready: &result, Role: userJoinRoomBean.Role,
+		PlayerId: userJoinRoomBean.PlayerId, UserToken: userJoinRoomBean.UserToken}
+	room.Users.Set(userJoinRoomBean.UserId, tmpUser)
+	if room.HostUserId == "" { // the first user is the host
+		room.HostUserId = tmpUser.UserId
+	}
+	saveRoom(room)
+	return nil
 }
 
-func roomQuitHandler(w http.ResponseWriter, r *http.Request) {
-	result := true
-	userId := r.URL.Query().Get("userId")
+func quitRoom(roomId string, userId string) (*UserJoinRoomBean, *apiError) {
 	if userId == "" {
-		result = false
-		println("user id is empty!!")
-	}
-	roomId := r.URL.Query().Get("roomId")
-	roomInterface, roomExist := roomsMap.Get(roomId)
-	if roomExist == false {
-		result = false
-		println("this room is not exist!!")
-	} else {
-		room := roomInterface.(*Room)
-		_, usertExist := room.Users.Get(userId)
-		if usertExist == false {
-			result = false
-			println("this user is not exist!!")
+		roomLog(roomId, userId).debug("quit fail, user id is empty")
+		return nil, errUserNotFound
+	}
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		roomLog(roomId, userId).debug("quit fail, room is not exist")
+		return nil, errRoomNotFound
+	}
+	user, userExist := room.Users.Get(userId)
+	if !userExist {
+		roomLog(roomId, userId).debug("quit fail, user is not exist")
+		return nil, errUserNotFound
+	}
+	result := true
+	userJoinRoomBean := &UserJoinRoomBean{userId, user.UserName, roomId, room.RoomName, &result, "", user.PlayerId, "", ""}
+	removeRoomUser(room, userId)
+	return userJoinRoomBean, nil
+}
+
+// removeRoomUser removes the user, hands the host to another user
+// and closes the room when nobody is left
+func removeRoomUser(room *Room, userId string) {
+	room.Users.Remove(userId)
+	if room.HostUserId == userId { // the user joined earliest is the next host
+		var host *User
+		for _, user := range room.Users.List() {
+			if host == nil || user.DrawOrder < host.DrawOrder {
+				host = user
+			}
+		}
+		room.HostUserId = ""
+		if host != nil {
+			room.HostUserId = host.UserId
 		}
 	}
-
-	userJoinRoomBean := &UserJoinRoomBean{"", "", "", "", &result, ""}
-	if result {
-		room := roomInterface.(*Room)
-		userJoinRoomBean.RoomId = roomId
-		userJoinRoomBean.UserId = userId
-		userInterface, _ := room.Users.Get(userId) //
-		user := userInterface.(*User)
-		userJoinRoomBean.UserName = user.UserName
-		userJoinRoomBean.RoomName = room.RoomName
-		userJoinRoomBean.Result = &result
-		room.Users.Remove(userId)
-		if room.Users.Count() == 0 {
-			roomsMap.Remove(roomId)
+	if room.Users.Count() == 0 {
+		if err := roomStore.Remove(room.RoomId); err != nil {
+			roomLog(room.RoomId, "").error("room remove fail", "err", err)
 		}
+		room.mutex.Lock()
+		game := finishGame(room)
+		round := endRound(room)
+		room.mutex.Unlock()
+		recordGame(game)
+		recordRound(round)
+		clearCustomWords(room)
+		return
 	}
+	saveRoom(room)
+}
 
-	jsonBytes, err := json.Marshal(userJoinRoomBean)
-	if err != nil {
-		println(err)
-		return
+func startDraw(roomId string) (*TopicDetail, *apiError) {
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return nil, errRoomNotFound
+	}
+	if room.Users.Count() == 0 {
+		return nil, errRoomEmpty
+	}
+	room.mutex.Lock()
+	var game *GameRecord
+	if gameFinishing(room) {
+		game = finishGame(room)
+	}
+	picked := randomRoomTopic(room)
+	if picked == nil {
+		room.mutex.Unlock()
+		recordGame(game)
+		return nil, errTopicNotFound
+	}
+	result := true
+	topicDetail := &TopicDetail{"", "", "", "", &result}
+	room.TopicDetail = topicDetail
+	round := startRound(room, picked)
+	userId := userToDrawDispatcher(room)
+	topicDetail.Category = picked.Category
+	topicDetail.Topic = picked.Topic
+	topicDetail.CurrentDrawUserId = userId
+	topicDetail.NextDrawUserId = getNextDrawOrderUserId(room)
+	startGameRound(room, picked)
+	room.mutex.Unlock()
+	recordGame(game)
+	recordRound(round)
+	saveRoom(room)
+	return topicDetail, nil
+}
+
+func getRoomTopicDetail(roomId string) (*TopicDetail, *apiError) {
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return nil, errRoomNotFound
+	}
+	if room.TopicDetail == nil || room.Users.Count() == 0 {
+		return nil, errTopicNotFound
+	}
+	result := true
+	room.TopicDetail.Result = &result
+	return room.TopicDetail, nil
+}
+
+func cleanAllRooms() {
+	for _, room := range roomStore.List() {
+		if err := roomStore.Remove(room.RoomId); err != nil {
+			roomLog(room.RoomId, "").error("room remove fail", "err", err)
+		}
+		clearCustomWords(room)
 	}
-	fmt.Fprintln(w, string(jsonBytes))
 }
 
 func generateUserId() string {
 	return generateUuId()
 }
 
+func generatePlayerToken() string {
+	return generateUuId()
+}
+
+func generateUserToken() string {
+	return generateUuId()
+}
+
 func generateRoomId() string {
 	return generateUuId()
 }
@@ -832,15 +886,19 @@ func generateUuId() string {
 }
 
 func getSampleTopicFile(fileName string, v interface{}) error {
-	file, err := os.Open("sample/topic/" + fileName)
+	return readJSONFile(topicDir+fileName, v)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Println(err)
+		appLog.debug("json file open fail", "path", path, "err", err)
 		return err
 	}
 	defer file.Close()
 	err = json.NewDecoder(file).Decode(v)
 	if err != nil {
-		log.Println(err)
+		appLog.warn("json file is invalid", "path", path, "err", err)
 		return err
 	}
 	return nil
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxMetricLabels = 50 // label values kept per metric, the others are counted as "other"
+
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a prometheus counter with one label, a gauge if values also go down
+type counterVec struct {
+	mutex  sync.Mutex
+	name   string
+	help   string
+	kind   string // counter or gauge
+	label  string
+	values map[string]float64
+}
+
+// histogramVec is a prometheus histogram with one label
+type histogramVec struct {
+	mutex   sync.Mutex
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+var (
+	socketsGauge = &counterVec{name: "draw_guess_sockets", help: "Connected websockets by channel, a multiplexed socket counts in both.",
+		kind: "gauge", label: "channel"}
+	messagesIn = &counterVec{name: "draw_guess_messages_in_total", help: "Room messages received by message type.",
+		kind: "counter", label: "type"}
+	messagesOut = &counterVec{name: "draw_guess_messages_out_total", help: "Room messages sent to each user by message type.",
+		kind: "counter", label: "type"}
+	drawBytes = &counterVec{name: "draw_guess_draw_bytes_total", help: "Draw data relayed to the other users in bytes.",
+		kind: "counter"}
+	drawDrops = &counterVec{name: "draw_guess_draw_frames_dropped_total", help: "Draw frames dropped by the frame limit of their sender.",
+		kind: "counter"}
+	answerChecks = &counterVec{name: "draw_guess_answer_checks_total", help: "Answers checked by result.",
+		kind: "counter", label: "result"}
+	joinFailures = &counterVec{name: "draw_guess_join_failures_total", help: "Failed room joins by reason.",
+		kind: "counter", label: "reason"}
+	originRejections = &counterVec{name: "draw_guess_origin_rejections_total", help: "Websocket upgrades rejected by their Origin header, by handler.",
+		kind: "counter", label: "handler"}
+	socketDrops = &counterVec{name: "draw_guess_socket_drops_total", help: "Websockets closed by the server, by reason.",
+		kind: "counter", label: "reason"}
+	rateLimited = &counterVec{name: "draw_guess_rate_limited_total", help: "Requests and room messages rejected by the rate limits, by action.",
+		kind: "counter", label: "action"}
+	handlerLatency = &histogramVec{name: "draw_guess_http_request_duration_seconds", help: "Latency of the http handlers.",
+		label: "handler", buckets: latencyBuckets}
+)
+
+func (v *counterVec) add(labelValue string, delta float64) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.values == nil {
+		v.values = map[string]float64{}
+	}
+	if _, exist := v.values[labelValue]; !exist && len(v.values) >= maxMetricLabels {
+		labelValue = "other"
+	}
+	v.values[labelValue] += delta
+}
+
+func (v *counterVec) inc(labelValue string) {
+	v.add(labelValue, 1)
+}
+
+func (v *counterVec) get(labelValue string) float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.values[labelValue]
+}
+
+func (v *counterVec) write(buf *bytes.Buffer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	writeMetricHeader(buf, v.name, v.help, v.kind)
+	if v.label == "" {
+		fmt.Fprintf(buf, "%s %s\n", v.name, formatMetricValue(v.values[""]))
+		return
+	}
+	for _, labelValue := range sortedKeys(v.values) {
+		fmt.Fprintf(buf, "%s{%s=\"%s\"} %s\n", v.name, v.label, escapeLabelValue(labelValue), formatMetricValue(v.values[labelValue]))
+	}
+}
+
+func (v *histogramVec) observe(labelValue string, value float64) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.values == nil {
+		v.values = map[string]*histogram{}
+	}
+	h, exist := v.values[labelValue]
+	if !exist {
+		if len(v.values) >= maxMetricLabels {
+			labelValue = "other"
+		}
+		if h, exist = v.values[labelValue]; !exist {
+			h = &histogram{counts: make([]uint64, len(v.buckets))}
+			v.values[labelValue] = h
+		}
+	}
+	for i, bound := range v.buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (v *histogramVec) write(buf *bytes.Buffer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	writeMetricHeader(buf, v.name, v.help, "histogram")
+	labelValues := make([]string, 0, len(v.values))
+	for labelValue := range v.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	for _, labelValue := range labelValues {
+		h := v.values[labelValue]
+		label := v.label + "=\"" + escapeLabelValue(labelValue) + "\""
+		var cumulative uint64
+		for i, bound := range v.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", v.name, label, formatMetricValue(bound), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", v.name, label, h.count)
+		fmt.Fprintf(buf, "%s_sum{%s} %s\n", v.name, label, formatMetricValue(h.sum))
+		fmt.Fprintf(buf, "%s_count{%s} %d\n", v.name, label, h.count)
+	}
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name string, help string, kind string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(buf *bytes.Buffer, name string, help string, value float64) {
+	writeMetricHeader(buf, name, help, "gauge")
+	fmt.Fprintf(buf, "%s %s\n", name, formatMetricValue(value))
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// recordAnswerCheck counts a checked answer by whether it matched the topic
+func recordAnswerCheck(correct bool) {
+	if correct {
+		answerChecks.inc("correct")
+	} else {
+		answerChecks.inc("wrong")
+	}
+}
+
+// instrumentHandler observes the latency of handler, not for sockets and streams
+// which stay open as long as the client
+func instrumentHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler(w, r)
+		handlerLatency.observe(name, time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler serves the metrics in the prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	writeGauge(buf, "draw_guess_active_rooms", "Rooms in the room store.", float64(roomStore.Count()))
+	socketsGauge.write(buf)
+	messagesIn.write(buf)
+	messagesOut.write(buf)
+	drawBytes.write(buf)
+	drawDrops.write(buf)
+	answerChecks.write(buf)
+	correct, wrong := answerChecks.get("correct"), answerChecks.get("wrong")
+	ratio := 0.0
+	if correct+wrong > 0 {
+		ratio = correct / (correct + wrong)
+	}
+	writeGauge(buf, "draw_guess_answer_correct_ratio", "Correct answers out of every checked answer since start.", ratio)
+	joinFailures.write(buf)
+	originRejections.write(buf)
+	socketDrops.write(buf)
+	rateLimited.write(buf)
+	handlerLatency.write(buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var openAPIFile = "public/openapi.json"
+
+// the go type of every schema in openapi.json, checked by validateOpenAPISpec
+var openAPISchemaTypes = map[string]reflect.Type{
+	"RoomBean":          reflect.TypeOf(RoomBean{}),
+	"UserBean":          reflect.TypeOf(UserBean{}),
+	"UserJoinRoomBean":  reflect.TypeOf(UserJoinRoomBean{}),
+	"TopicDetail":       reflect.TypeOf(TopicDetail{}),
+	"Topic":             reflect.TypeOf(Topic{}),
+	"TopicWord":         reflect.TypeOf(TopicWord{}),
+	"LocaleWord":        reflect.TypeOf(LocaleWord{}),
+	"Category":          reflect.TypeOf(Category{}),
+	"CustomWordsBean":   reflect.TypeOf(CustomWordsBean{}),
+	"CategoryBean":      reflect.TypeOf(CategoryBean{}),
+	"TopicReloadReport": reflect.TypeOf(TopicReloadReport{}),
+	"TopicStat":         reflect.TypeOf(TopicStat{}),
+	"TopicBundle":       reflect.TypeOf(TopicBundle{}),
+	"ImportReport":      reflect.TypeOf(ImportReport{}),
+	"TopicPack":         reflect.TypeOf(TopicPack{}),
+	"PackBean":          reflect.TypeOf(PackBean{}),
+	"RoomPacksBean":     reflect.TypeOf(RoomPacksBean{}),
+	"GameRecord":        reflect.TypeOf(GameRecord{}),
+	"GamePlayer":        reflect.TypeOf(GamePlayer{}),
+	"GameRound":         reflect.TypeOf(GameRound{}),
+	"GameGuess":         reflect.TypeOf(GameGuess{}),
+	"PlayerProfile":     reflect.TypeOf(PlayerProfile{}),
+	"PlayerStats":       reflect.TypeOf(PlayerStats{}),
+	"LeaderboardEntry":  reflect.TypeOf(LeaderboardEntry{}),
+	"LeaderboardPage":   reflect.TypeOf(LeaderboardPage{}),
+	"HealthBean":        reflect.TypeOf(HealthBean{}),
+	"HealthCheck":       reflect.TypeOf(HealthCheck{}),
+	"DebugState":        reflect.TypeOf(DebugState{}),
+	"DebugRoomState":    reflect.TypeOf(DebugRoomState{}),
+	"ErrorBean":         reflect.TypeOf(ErrorBean{}),
+	"ErrorDetail":       reflect.TypeOf(ErrorDetail{}),
+	"Message":           reflect.TypeOf(Message{}),
+	"Envelope":          reflect.TypeOf(Envelope{}),
+}
+
+type openAPISchema struct {
+	Type                 string                    `json:"type"`
+	Ref                  string                    `json:"$ref"`
+	Items                *openAPISchema            `json:"items"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties"`
+}
+
+// openAPIContent is the body schema by media type
+type openAPIContent map[string]struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIOperation struct {
+	RequestBody *struct {
+		Content openAPIContent `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content openAPIContent `json:"content"`
+	} `json:"responses"`
+}
+
+type openAPISpec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"` // operations by method
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	byteValue, err := ioutil.ReadFile(openAPIFile)
+	if err != nil {
+		appLog.warn("openapi file fail", "err", err)
+		writeAPIError(w, errNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(byteValue))
+}
+
+// validateOpenAPISpec checks the schemas and /api/v1 paths in openapi.json still match the go types and apiV1Routes
+func validateOpenAPISpec() error {
+	byteValue, err := ioutil.ReadFile(openAPIFile)
+	if err != nil {
+		return err
+	}
+	spec := &openAPISpec{}
+	err = json.Unmarshal(byteValue, spec)
+	if err != nil {
+		return fmt.Errorf("%s: %v", openAPIFile, err)
+	}
+	problems := []string{}
+	for name, goType := range openAPISchemaTypes {
+		schema, exist := spec.Components.Schemas[name]
+		if !exist {
+			problems = append(problems, name+": missing in spec")
+			continue
+		}
+		problems = append(problems, compareSchema(name, schema, goType)...)
+	}
+	problems = append(problems, comparePaths(spec)...)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s drift from the go types and routes:\n  %s", openAPIFile, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// comparePaths checks every /api/v1 route is in the spec paths with the json bodies of the route,
+// and every /api/v1 operation of the spec is routed
+func comparePaths(spec *openAPISpec) []string {
+	problems := []string{}
+	routed := map[string]bool{}
+	for _, route := range apiV1Routes {
+		parts := strings.Split(route.Pattern, "/")
+		for i, part := range parts {
+			if strings.HasPrefix(part, ":") {
+				parts[i] = "{" + part[1:] + "}"
+			}
+		}
+		path := "/api/v1" + strings.Join(parts, "/")
+		method := strings.ToLower(route.Method)
+		routed[method+" "+path] = true
+		raw, exist := spec.Paths[path][method]
+		if !exist {
+			problems = append(problems, route.Method+" "+path+": missing in spec")
+			continue
+		}
+		operation := &openAPIOperation{}
+		if err := json.Unmarshal(raw, operation); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s: %v", route.Method, path, err))
+			continue
+		}
+		request := ""
+		if operation.RequestBody != nil {
+			request = specBodyName(operation.RequestBody.Content)
+		}
+		if want := goBodyName(route.Request); request != want {
+			problems = append(problems, fmt.Sprintf("%s %s: spec request %q, go request %q", route.Method, path, request, want))
+		}
+		if response, want := specBodyName(successContent(operation)), goBodyName(route.Response); response != want {
+			problems = append(problems, fmt.Sprintf("%s %s: spec response %q, go response %q", route.Method, path, response, want))
+		}
+	}
+	for path, operations := range spec.Paths {
+		if !strings.HasPrefix(path, "/api/v1/") {
+			continue
+		}
+		for method := range operations {
+			switch method {
+			case "get", "put", "post", "delete", "patch":
+				if !routed[method+" "+path] {
+					problems = append(problems, strings.ToUpper(method)+" "+path+": not in apiV1Routes")
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// successContent is the content of the first 2xx response of operation
+func successContent(operation *openAPIOperation) openAPIContent {
+	codes := []string{}
+	for code := range operation.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	sort.Strings(codes)
+	return operation.Responses[codes[0]].Content
+}
+
+// specBodyName names the application/json schema of content like goBodyName, e.g. RoomBean,
+// []RoomBean or map[Topic], "" without a json body
+func specBodyName(content openAPIContent) string {
+	media, exist := content["application/json"]
+	if !exist {
+		return ""
+	}
+	return specSchemaName(media.Schema)
+}
+
+func specSchemaName(schema *openAPISchema) string {
+	switch {
+	case schema == nil:
+		return ""
+	case schema.Ref != "":
+		return schema.Ref[strings.LastIndex(schema.Ref, "/")+1:]
+	case schema.Type == "array":
+		return "[]" + specSchemaName(schema.Items)
+	case schema.Type == "object" && schema.AdditionalProperties != nil:
+		if name := specSchemaName(schema.AdditionalProperties); name != "object" {
+			return "map[" + name + "]"
+		}
+	}
+	return schema.Type
+}
+
+// goBodyName names the type of body by its schema in openAPISchemaTypes, a type without one is an object
+func goBodyName(body interface{}) string {
+	if body == nil {
+		return ""
+	}
+	return goTypeName(reflect.TypeOf(body))
+}
+
+func goTypeName(goType reflect.Type) string {
+	for goType.Kind() == reflect.Ptr {
+		goType = goType.Elem()
+	}
+	for name, schemaType := range openAPISchemaTypes {
+		if schemaType == goType {
+			return name
+		}
+	}
+	switch goType.Kind() {
+	case reflect.Slice:
+		if goType != reflect.TypeOf(json.RawMessage{}) {
+			return "[]" + goTypeName(goType.Elem())
+		}
+	case reflect.Map:
+		if name := goTypeName(goType.Elem()); name != "object" {
+			return "map[" + name + "]"
+		}
+	}
+	return "object"
+}
+
+func compareSchema(name string, schema *openAPISchema, goType reflect.Type) []string {
+	problems := []string{}
+	fields := jsonFields(goType)
+	for field, fieldType := range fields {
+		property, exist := schema.Properties[field]
+		if !exist {
+			problems = append(problems, name+"."+field+": missing in spec")
+			continue
+		}
+		if want := openAPIType(fieldType); want != "" && property.Ref == "" && property.Type != want {
+			problems = append(problems, fmt.Sprintf("%s.%s: spec type %q, go type %q", name, field, property.Type, want))
+		}
+	}
+	for property := range schema.Properties {
+		if _, exist := fields[property]; !exist {
+			problems = append(problems, name+"."+property+": not in go type")
+		}
+	}
+	return problems
+}
+
+// jsonFields returns the json names of the exported fields of goType
+func jsonFields(goType reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if tagName := strings.Split(tag, ",")[0]; tagName != "" {
+				name = tagName
+			}
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// openAPIType maps a go type to the openapi type, "" means any
+func openAPIType(goType reflect.Type) string {
+	for goType.Kind() == reflect.Ptr {
+		goType = goType.Elem()
+	}
+	if goType == reflect.TypeOf(json.RawMessage{}) {
+		return ""
+	}
+	if goType == reflect.TypeOf(time.Time{}) {
+		return "string"
+	}
+	switch goType.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return ""
+}
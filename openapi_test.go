@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+func TestOpenAPISpec(t *testing.T) {
+	if err := validateOpenAPISpec(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var maxDisplayNameLength = 32 // in characters
+
+// PlayerProfile is a player kept across games, a user joining a room with its playerId
+// and token adds the finished games to the lifetime statistics
+type PlayerProfile struct {
+	PlayerId    string      `json:"playerId"`
+	Token       string      `json:"token,omitempty"` // secret of the player, only returned when it is created
+	DisplayName string      `json:"displayName"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	Stats       PlayerStats `json:"stats"`
+	Result      *bool       `json:"result,omitempty"`
+}
+
+type PlayerStats struct {
+	GamesPlayed       int     `json:"gamesPlayed"`
+	Wins              int     `json:"wins"`
+	CorrectGuesses    int     `json:"correctGuesses"`
+	TotalGuessSeconds float64 `json:"totalGuessSeconds"`
+	AvgGuessSeconds   float64 `json:"avgGuessSeconds"`
+	DrawingsGuessed   int     `json:"drawingsGuessed"` // drawings of the player guessed by anyone
+}
+
+var (
+	errPlayerNotFound     = &apiError{http.StatusNotFound, "player_not_found", "player is not exist"}
+	errInvalidDisplayName = &apiError{http.StatusUnprocessableEntity, "invalid_display_name", "displayName is empty or too long"}
+	errProfileStorage     = &apiError{http.StatusInternalServerError, "storage_error", "fail to write player profiles"}
+	errPlayerToken        = &apiError{http.StatusForbidden, "invalid_player_token", "player token is missing or wrong"}
+)
+
+// checkPlayerToken is true if token is the token of profile, a profile without one can not be used
+func checkPlayerToken(profile *PlayerProfile, token string) bool {
+	return profile.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(profile.Token)) == 1
+}
+
+func checkDisplayName(name string) (string, *apiError) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxDisplayNameLength {
+		return "", errInvalidDisplayName
+	}
+	return name, nil
+}
+
+func createPlayer(bean *PlayerProfile) (*PlayerProfile, *apiError) {
+	name, apiErr := checkDisplayName(bean.DisplayName)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	profile := &PlayerProfile{PlayerId: generateUuId(), Token: generatePlayerToken(), DisplayName: name, CreatedAt: time.Now()}
+	if err := profileStore.Save(profile); err != nil {
+		appLog.error("profile save fail", "playerId", profile.PlayerId, "err", err)
+		return nil, errProfileStorage
+	}
+	return profile, nil
+}
+
+func renamePlayer(playerId string, token string, displayName string) (*PlayerProfile, *apiError) {
+	name, apiErr := checkDisplayName(displayName)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	profile, exist := profileStore.Get(playerId)
+	if !exist {
+		return nil, errPlayerNotFound
+	}
+	if !checkPlayerToken(profile, token) {
+		return nil, errPlayerToken
+	}
+	profile, err := profileStore.Update(playerId, func(profile *PlayerProfile) {
+		profile.DisplayName = name
+	})
+	if err != nil {
+		appLog.error("profile save fail", "playerId", playerId, "err", err)
+		return nil, errProfileStorage
+	}
+	if profile == nil {
+		return nil, errPlayerNotFound
+	}
+	return profile, nil
+}
+
+// addGameStats adds a finished game to the statistics of its players with a profile
+func addGameStats(game *GameRecord) {
+	winners := map[string]bool{}
+	for _, userId := range game.Winners {
+		winners[userId] = true
+	}
+	for _, player := range game.Players {
+		if player.PlayerId == "" {
+			continue
+		}
+		userId := player.UserId
+		_, err := profileStore.Update(player.PlayerId, func(profile *PlayerProfile) {
+			stats := &profile.Stats
+			stats.GamesPlayed++
+			if winners[userId] {
+				stats.Wins++
+			}
+			for _, round := range game.Rounds {
+				if round.DrawerId == userId && len(round.Guesses) > 0 {
+					stats.DrawingsGuessed++
+				}
+				for _, guess := range round.Guesses {
+					if guess.UserId == userId {
+						stats.CorrectGuesses++
+						stats.TotalGuessSeconds += guess.Seconds
+					}
+				}
+			}
+			if stats.CorrectGuesses > 0 {
+				stats.AvgGuessSeconds = stats.TotalGuessSeconds / float64(stats.CorrectGuesses)
+			}
+		})
+		if err != nil {
+			appLog.error("profile stats fail", "playerId", player.PlayerId, "err", err)
+		}
+	}
+}
+
+func apiPlayerCreateHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &PlayerProfile{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	profile, apiErr := createPlayer(bean)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	result := true
+	profile.Result = &result
+	writeJSON(w, http.StatusCreated, profile)
+}
+
+func apiPlayerHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	profile, exist := profileStore.Get(params["playerId"])
+	if !exist {
+		writeAPIError(w, errPlayerNotFound)
+		return
+	}
+	profile.Token = ""
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func apiPlayerRenameHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &PlayerProfile{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	profile, apiErr := renamePlayer(params["playerId"], bean.Token, bean.DisplayName)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	profile.Token = ""
+	writeJSON(w, http.StatusOK, profile)
+}
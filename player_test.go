@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestProfileStore(t *testing.T) {
+	eachBackend(t, func(t *testing.T, reopen func()) {
+		if err := profileStore.Save(&PlayerProfile{PlayerId: "p1", DisplayName: "Ann"}); err != nil {
+			t.Fatal(err)
+		}
+		profile, err := profileStore.Update("p1", func(profile *PlayerProfile) {
+			profile.Stats.Wins++
+		})
+		if err != nil || profile == nil || profile.Stats.Wins != 1 {
+			t.Fatalf("update %+v, %v", profile, err)
+		}
+		profile.DisplayName = "changed copy"
+		if profile, err := profileStore.Update("p2", func(profile *PlayerProfile) {}); profile != nil || err != nil {
+			t.Fatalf("update of a missing profile %+v, %v", profile, err)
+		}
+		reopen()
+		got, exist := profileStore.Get("p1")
+		if !exist || got.DisplayName != "Ann" || got.Stats.Wins != 1 {
+			t.Fatalf("p1 %+v", got)
+		}
+	})
+}
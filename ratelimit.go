@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var roomCreatesPerMinute = 10      // of an ip, 0 means no limit
+var roomJoinsPerMinute = 30        // of an ip
+var answersPerMinute = 60          // of a user
+var chatsPerMinute = 60            // room messages of a user relayed as is
+var playerRoomCreatesPerMinute = 5 // of a player profile
+var playerRoomJoinsPerMinute = 20  // of a player profile
+var ipAnswersPerMinute = 300       // of the users of an ip
+var ipChatsPerMinute = 300         // of the users of an ip
+
+// trustProxy takes the client ip from X-Forwarded-For, on by default behind the heroku router
+// where every request comes from the router
+var trustProxy = os.Getenv("DYNO") != ""
+
+var errRateLimited = &apiError{http.StatusTooManyRequests, "rate_limited", "too many requests, try again later"}
+
+const (
+	actionRoomCreate = "roomCreate"
+	actionRoomJoin   = "roomJoin"
+	actionAnswer     = "answer"
+	actionChat       = "chat"
+)
+
+// rateLimiter keeps a token bucket of each key, e.g. an ip or a userId, buckets
+// idle long enough to be full again are dropped
+type rateLimiter struct {
+	action  string
+	perMin  *int
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// ipRateLimiters limit each action by the client ip
+var ipRateLimiters = map[string]*rateLimiter{
+	actionRoomCreate: {action: actionRoomCreate, perMin: &roomCreatesPerMinute},
+	actionRoomJoin:   {action: actionRoomJoin, perMin: &roomJoinsPerMinute},
+	actionAnswer:     {action: actionAnswer, perMin: &ipAnswersPerMinute},
+	actionChat:       {action: actionChat, perMin: &ipChatsPerMinute},
+}
+
+// userRateLimiters limit each action by the user, the player profile for the rest requests
+// and the userId for the room messages
+var userRateLimiters = map[string]*rateLimiter{
+	actionRoomCreate: {action: actionRoomCreate, perMin: &playerRoomCreatesPerMinute},
+	actionRoomJoin:   {action: actionRoomJoin, perMin: &playerRoomJoinsPerMinute},
+	actionAnswer:     {action: actionAnswer, perMin: &answersPerMinute},
+	actionChat:       {action: actionChat, perMin: &chatsPerMinute},
+}
+
+// allow takes a token of key, false and the counter goes up if the bucket is empty
+func (l *rateLimiter) allow(key string) bool {
+	if *l.perMin <= 0 {
+		return true
+	}
+	l.mutex.Lock()
+	now := time.Now()
+	if now.Sub(l.pruned) > time.Minute {
+		for k, b := range l.buckets {
+			if b.idle(now) > time.Minute { // last is written by allow under the bucket mutex
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	bucket, exist := l.buckets[key]
+	if !exist {
+		bucket = newTokenBucket(float64(*l.perMin)/60, float64(*l.perMin))
+		l.buckets[key] = bucket
+	}
+	l.mutex.Unlock()
+	if bucket.allow() {
+		return true
+	}
+	rateLimited.inc(l.action)
+	return false
+}
+
+// retryAfter is the seconds until the next token
+func (l *rateLimiter) retryAfter() int {
+	if *l.perMin <= 0 {
+		return 0
+	}
+	return (60 + *l.perMin - 1) / *l.perMin
+}
+
+// clientIP is the ip of the request, the last X-Forwarded-For entry if trustProxy is set,
+// the one added by the proxy, the entries before it are sent by the client
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkRate writes 429 and returns false if the ip of the request is over the limit of action
+func checkRate(action string, w http.ResponseWriter, r *http.Request) bool {
+	limiter := ipRateLimiters[action]
+	ip := clientIP(r)
+	if limiter.allow(ip) {
+		return true
+	}
+	appLog.info("rate limited", "action", action, "ip", ip)
+	w.Header().Set("Retry-After", strconv.Itoa(limiter.retryAfter()))
+	writeAPIError(w, errRateLimited)
+	return false
+}
+
+// checkPlayerRate writes 429 and returns false if the player is over the limit of action,
+// requests without a player or with a wrong token are only limited by ip
+func checkPlayerRate(action string, playerId string, token string, w http.ResponseWriter) bool {
+	if playerId == "" {
+		return true
+	}
+	profile, exist := profileStore.Get(playerId)
+	if !exist || !checkPlayerToken(profile, token) {
+		return true
+	}
+	limiter := userRateLimiters[action]
+	if limiter.allow(playerId) {
+		return true
+	}
+	appLog.info("rate limited", "action", action, "playerId", playerId)
+	w.Header().Set("Retry-After", strconv.Itoa(limiter.retryAfter()))
+	writeAPIError(w, errRateLimited)
+	return false
+}
+
+// rateLimitCounts is the rejected requests and messages by action since start
+func rateLimitCounts() map[string]int {
+	counts := map[string]int{}
+	for action := range ipRateLimiters {
+		counts[action] = int(rateLimited.get(action))
+	}
+	return counts
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(trust bool) { trustProxy = trust }(trustProxy)
+	cases := []struct {
+		trust     bool
+		forwarded string
+		want      string
+	}{
+		{false, "", "10.0.0.1"},
+		{false, "1.2.3.4", "10.0.0.1"},
+		{true, "", "10.0.0.1"},
+		{true, "5.6.7.8", "5.6.7.8"},
+		{true, "1.2.3.4, 5.6.7.8", "5.6.7.8"}, // the first entry is sent by the client
+	}
+	for _, c := range cases {
+		trustProxy = c.trust
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := clientIP(r); got != c.want {
+			t.Errorf("trust %t, X-Forwarded-For %q: %s, want %s", c.trust, c.forwarded, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var roomSnapshotFile = ""                   // dataDir/rooms.json if empty
+var roomSnapshotInterval = 30 * time.Second // 0 only saves on shutdown
+var reconnectGrace = 60 * time.Second       // restored users not reconnected in time quit the room
+
+// saveRoomSnapshot writes every room with its users, scores and current topic to roomSnapshotFile
+func saveRoomSnapshot() error {
+	records := []*roomRecord{}
+	for _, room := range roomStore.List() {
+		records = append(records, newRoomRecord(room))
+	}
+	jsonBytes, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(roomSnapshotFile), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(roomSnapshotFile, jsonBytes)
+}
+
+// restoreRooms adds the rooms of the snapshot missing in the room store, then
+// gives every user of the restored rooms reconnectGrace to connect again with the same ids
+func restoreRooms() {
+	records := []*roomRecord{}
+	err := readJSONFile(roomSnapshotFile, &records)
+	if err != nil && !os.IsNotExist(err) {
+		appLog.error("room snapshot fail", "err", err)
+	}
+	for _, record := range records {
+		if record.RoomId == "" {
+			continue
+		}
+		if _, exist := roomStore.Get(record.RoomId); !exist {
+			saveRoom(record.room())
+		}
+	}
+	rooms := roomStore.List()
+	for _, room := range rooms {
+		for _, user := range room.Users.List() {
+			waitReconnect(room.RoomId, user)
+		}
+	}
+	if len(rooms) > 0 {
+		appLog.info("rooms restore success", "rooms", len(rooms))
+	}
+}
+
+func waitReconnect(roomId string, user *User) {
+	time.AfterFunc(reconnectGrace, func() {
+		_, currentUser, exist := getRoomUser(roomId, user.UserId)
+		if !exist || currentUser != user {
+			return
+		}
+		if user.RoomConn != nil || user.DrawConn != nil || user.pollConn != nil {
+			return
+		}
+		roomLog(roomId, user.UserId).info("user not reconnected, quit the room")
+		userQuitRoom(roomId, user)
+	})
+}
+
+// watchRoomSnapshot saves the rooms every roomSnapshotInterval, watchShutdown saves them before exit
+func watchRoomSnapshot() {
+	if roomSnapshotInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(roomSnapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := saveRoomSnapshot(); err != nil {
+			appLog.error("room snapshot fail", "err", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+var guessScore = 10    // the first correct guess of a round
+var guessScoreStep = 2 // less for each guesser before
+var minGuessScore = 2
+var drawScore = 2                // the drawer gets for each correct guesser
+var roundTimeLimit time.Duration // correct guesses after it score nothing, 0 means no limit
+
+// scoreCorrectGuess gives the scores of a correct guess to the guesser and the drawer,
+// it returns false if the user has guessed this round already or the round is over its time limit
+func scoreCorrectGuess(room *Room, userId string) bool {
+	if roundTimeLimit > 0 && !room.round.startedAt.IsZero() && time.Since(room.round.startedAt) > roundTimeLimit {
+		return false
+	}
+	if room.round.guessed == nil {
+		room.round.guessed = map[string]bool{}
+	}
+	if room.round.guessed[userId] {
+		return false
+	}
+	score := guessScore - guessScoreStep*len(room.round.guessed)
+	if score < minGuessScore {
+		score = minGuessScore
+	}
+	room.round.guessed[userId] = true
+	if user, exist := room.Users.Get(userId); exist {
+		user.Score += score
+		recordGameGuess(room, user, score)
+	}
+	if drawer, exist := room.Users.Get(room.TopicDetail.CurrentDrawUserId); exist {
+		drawer.Score += drawScore
+	}
+	return true
+}
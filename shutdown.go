@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var shutdownTimeout = 10 * time.Second // sockets still open then are closed without waiting
+
+var shuttingDown int32               // set once SIGINT or SIGTERM is received
+var shutdownCh = make(chan struct{}) // closed to end the sse streams and long polls
+
+var errShuttingDown = &apiError{http.StatusServiceUnavailable, "shutting_down", "server is shutting down, try again later"}
+
+// liveConns are the open websockets, drained on shutdown
+var liveConns = struct {
+	sync.Mutex
+	conns map[*hubConn]bool
+	empty chan struct{} // closed when the last socket closes during shutdown
+}{conns: map[*hubConn]bool{}}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// addLiveConn tracks an upgraded websocket, false if the shutdown has begun
+func addLiveConn(hc *hubConn) bool {
+	liveConns.Lock()
+	defer liveConns.Unlock()
+	if isShuttingDown() {
+		return false
+	}
+	liveConns.conns[hc] = true
+	return true
+}
+
+func removeLiveConn(hc *hubConn) {
+	liveConns.Lock()
+	defer liveConns.Unlock()
+	delete(liveConns.conns, hc)
+	if len(liveConns.conns) == 0 && liveConns.empty != nil {
+		close(liveConns.empty)
+		liveConns.empty = nil
+	}
+}
+
+// closeLiveConns sends a going away close frame to every open websocket, and
+// returns a channel closed once the clients have closed them all
+func closeLiveConns() <-chan struct{} {
+	liveConns.Lock()
+	defer liveConns.Unlock()
+	empty := make(chan struct{})
+	if len(liveConns.conns) == 0 {
+		close(empty)
+		return empty
+	}
+	liveConns.empty = empty
+	for hc := range liveConns.conns {
+		if err := hc.closeWith(websocket.CloseGoingAway, "server shutdown"); err != nil {
+			hc.log.debug("close frame fail", "err", err)
+		}
+	}
+	return empty
+}
+
+// forceCloseLiveConns closes the websockets whose clients did not answer the close frame
+func forceCloseLiveConns() {
+	liveConns.Lock()
+	conns := make([]*hubConn, 0, len(liveConns.conns))
+	for hc := range liveConns.conns {
+		conns = append(conns, hc)
+	}
+	liveConns.Unlock()
+	for _, hc := range conns {
+		hc.log.warn("close without the client answer")
+		hc.close()
+	}
+}
+
+// notifyShutdown tells every room the server is stopping, message is the seconds
+// a user can reconnect with the same roomId and userId
+func notifyShutdown() {
+	result := true
+	seconds := strconv.Itoa(int(reconnectGrace / time.Second))
+	for _, room := range roomStore.List() {
+		sendReqMessage(&Message{"serverShutdown", "", "", room.RoomId, seconds, &result}, room, websocket.TextMessage)
+	}
+}
+
+// watchShutdown waits for SIGINT or SIGTERM, then stops accepting joins, notifies the rooms,
+// drains the connections until shutdownTimeout and saves the rooms, done gets the exit code
+func watchShutdown(server *http.Server, done chan<- int) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	appLog.info("shutdown", "signal", sig, "timeout", shutdownTimeout)
+	atomic.StoreInt32(&shuttingDown, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	notifyShutdown()
+	close(shutdownCh)
+	drained := closeLiveConns()
+	if err := server.Shutdown(ctx); err != nil {
+		appLog.warn("http server shutdown fail", "err", err)
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		forceCloseLiveConns()
+	}
+
+	code := 0
+	if err := saveRoomSnapshot(); err != nil {
+		appLog.error("room snapshot fail", "err", err)
+		code = 1
+	}
+	appLog.info("shutdown done")
+	done <- code
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var sseKeepAliveInterval = 15 * time.Second
+var pollWaitTimeout = 25 * time.Second // how long a poll waits for events
+var pollIdleTimeout = 60 * time.Second // quit the room if the client stop polling
+var eventBufferSize = 64               // room events kept for a slow client
+
+func newEventHubConn(connLog *logger) *hubConn {
+	hc := newHubConn(nil, false, connLog)
+	hc.events = make(chan []byte, eventBufferSize)
+	return hc
+}
+
+// sseRoomHandler serves /sse/room/{roomId}?userId=
+// GET streams the room messages as Server-Sent Events, POST sends one room message
+func sseRoomHandler(w http.ResponseWriter, r *http.Request) {
+	currentRoomId := strings.TrimPrefix(r.URL.Path, "/sse/room/")
+	currentUserId := r.URL.Query().Get("userId")
+	if r.Method == http.MethodPost {
+		roomSendHandler(w, r, currentRoomId, currentUserId)
+		return
+	}
+
+	_, currentUser, exist := getRoomUser(currentRoomId, currentUserId)
+	if !exist {
+		http.Error(w, "room or user not exist!!", http.StatusNotFound)
+		return
+	}
+	if isShuttingDown() {
+		writeAPIError(w, errShuttingDown)
+		return
+	}
+	if currentUser.RoomConn != nil {
+		http.Error(w, "user already connect room!!", http.StatusConflict)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported!!", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	hc := newEventHubConn(roomLog(currentRoomId, currentUserId).with("handler", "sseRoomHandler"))
+	attachRoomConn(currentUser, hc)
+	hc.log.info("connect")
+
+	defer func() {
+		hc.log.info("disconnect")
+		detachRoomConn(currentUser, hc)
+	}()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shutdownCh: // send the serverShutdown message queued before and end the stream
+			for {
+				select {
+				case msg := <-hc.events:
+					fmt.Fprintf(w, "data: %s\n\n", msg)
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case msg := <-hc.events:
+			_, err := fmt.Fprintf(w, "data: %s\n\n", msg)
+			if err != nil {
+				hc.log.info("write fail", "err", err)
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				hc.log.info("write fail", "err", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// pollRoomHandler serves /poll/room/{roomId}?userId=
+// GET waits for room messages and returns them as a json array, POST sends one room message.
+// the user quits the room after pollIdleTimeout without any poll.
+func pollRoomHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	currentRoomId := strings.TrimPrefix(r.URL.Path, "/poll/room/")
+	currentUserId := r.URL.Query().Get("userId")
+	if r.Method == http.MethodPost {
+		roomSendHandler(w, r, currentRoomId, currentUserId)
+		return
+	}
+
+	_, currentUser, exist := getRoomUser(currentRoomId, currentUserId)
+	if !exist {
+		http.Error(w, "room or user not exist!!", http.StatusNotFound)
+		return
+	}
+	hc := currentUser.pollConn
+	if hc == nil || currentUser.RoomConn != hc {
+		if isShuttingDown() {
+			writeAPIError(w, errShuttingDown)
+			return
+		}
+		if currentUser.RoomConn != nil {
+			http.Error(w, "user already connect room!!", http.StatusConflict)
+			return
+		}
+		hc = newEventHubConn(roomLog(currentRoomId, currentUserId).with("handler", "pollRoomHandler"))
+		currentUser.pollConn = hc
+		attachRoomConn(currentUser, hc)
+		currentUser.pollTimer = time.AfterFunc(pollIdleTimeout, func() {
+			hc.log.info("idle timeout, disconnect")
+			if currentUser.pollConn == hc {
+				currentUser.pollConn = nil
+			}
+			detachRoomConn(currentUser, hc)
+		})
+		hc.log.info("connect")
+	}
+	currentUser.pollTimer.Reset(pollWaitTimeout + pollIdleTimeout)
+
+	messages := make([]json.RawMessage, 0)
+	select {
+	case msg := <-hc.events:
+		messages = append(messages, msg)
+	case <-time.After(pollWaitTimeout):
+	case <-r.Context().Done():
+	case <-shutdownCh: // return the serverShutdown message queued before
+	}
+drain:
+	for len(messages) > 0 || isShuttingDown() { // drain the others already queued
+		select {
+		case msg := <-hc.events:
+			messages = append(messages, msg)
+		default:
+			break drain
+		}
+	}
+	currentUser.pollTimer.Reset(pollIdleTimeout)
+
+	jsonBytes, err := json.Marshal(messages)
+	if err != nil {
+		hc.log.error("json marshal fail", "err", err)
+		return
+	}
+	fmt.Fprint(w, string(jsonBytes))
+}
+
+// roomSendHandler handles a room message posted by a sse or long polling client
+func roomSendHandler(w http.ResponseWriter, r *http.Request, roomId string, userId string) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _, exist := getRoomUser(roomId, userId)
+	if !exist {
+		http.Error(w, "room or user not exist!!", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxRoomFrameBytes)))
+	if err != nil {
+		roomLog(roomId, userId).info("read fail", "err", err)
+		http.Error(w, "room message is too large!!", http.StatusRequestEntityTooLarge)
+		return
+	}
+	result := handleRoomFrame(roomId, userId, clientIP(r), websocket.TextMessage, body)
+	if !result {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	fmt.Fprintf(w, `{"result":%t}`, result)
+}
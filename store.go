@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	storeMemory = "memory"
+	storeFile   = "file"
+)
+
+var roomStoreType = storeMemory  // memory or file, rooms of the file store are kept in dataDir/rooms
+var topicStoreType = storeFile   // topics have always been kept in topicDir, memory drops admin changes on restart
+var historyStoreType = storeFile // finished games of the file store are appended to dataDir/history.jsonl
+var profileStoreType = storeFile // player profiles of the file store are kept in dataDir/players.json
+var statStoreType = storeFile    // topic statistics of the file store are kept in dataDir/topic_stats.json
+var dataDir = "data/"
+
+// RoomStore keeps the rooms by roomId, a room changed in place is written by Save
+type RoomStore interface {
+	Get(roomId string) (*Room, bool)
+	Save(room *Room) error
+	Remove(roomId string) error
+	List() []*Room
+	Count() int
+}
+
+// TopicStore keeps the topics by category, a topic is replaced as a whole and never changed in place
+type TopicStore interface {
+	Get(category string) (*Topic, bool)
+	Has(category string) bool
+	Categories() []string
+	All() map[string]*Topic
+	Save(category string, topic *Topic) error
+	Rename(category string, newCategory string) error
+	Remove(category string) error
+	Load(topics map[string]*Topic) // swap every topic as read from the files, nothing is written
+}
+
+// HistoryStore keeps the finished games, the lists are the newest first
+type HistoryStore interface {
+	Add(game *GameRecord) error
+	Get(gameId string) (*GameRecord, bool)
+	Recent(limit int) []*GameRecord
+	ByPlayer(userId string, limit int) []*GameRecord
+	Each(fn func(game *GameRecord)) error // every stored game, the oldest first
+}
+
+// ProfileStore keeps the player profiles, the profiles returned are copies
+type ProfileStore interface {
+	Get(playerId string) (*PlayerProfile, bool)
+	Save(profile *PlayerProfile) error
+	Update(playerId string, fn func(profile *PlayerProfile)) (*PlayerProfile, error) // nil if not exist
+}
+
+// TopicStatStore keeps the round statistics of the topics by topic key, the stats returned are copies
+type TopicStatStore interface {
+	Get(key string) (*TopicStat, bool)
+	All() map[string]*TopicStat
+	Update(key string, fn func(stat *TopicStat)) error // a new stat is created if not exist
+}
+
+var roomStore RoomStore
+var topicStore TopicStore
+var historyStore HistoryStore
+var profileStore ProfileStore
+var statStore TopicStatStore
+
+// openStores creates the stores of the settings, it fails if a file store can not be opened
+func openStores() error {
+	roomStore = newMemoryRoomStore()
+	topicStore = newMemoryTopicStore()
+	historyStore = newMemoryHistoryStore()
+	profileStore = newMemoryProfileStore()
+	statStore = newMemoryStatStore()
+	if roomStoreType == storeFile {
+		store, err := newFileRoomStore(dataDir + "rooms/")
+		if err != nil {
+			return fmt.Errorf("room store: %v", err)
+		}
+		roomStore = store
+	}
+	if topicStoreType == storeFile {
+		topicStore = newFileTopicStore(topicDir)
+	}
+	if historyStoreType == storeFile {
+		store, err := newFileHistoryStore(dataDir + "history.jsonl")
+		if err != nil {
+			return fmt.Errorf("history store: %v", err)
+		}
+		historyStore = store
+	}
+	if profileStoreType == storeFile {
+		store, err := newFileProfileStore(dataDir + "players.json")
+		if err != nil {
+			return fmt.Errorf("profile store: %v", err)
+		}
+		profileStore = store
+	}
+	if statStoreType == storeFile {
+		store, err := newFileStatStore(dataDir + "topic_stats.json")
+		if err != nil {
+			return fmt.Errorf("stat store: %v", err)
+		}
+		statStore = store
+	}
+	return nil
+}
+
+// RoomUsers are the users of a room by userId, safe for the socket goroutines
+type RoomUsers struct {
+	mutex sync.RWMutex
+	users map[string]*User
+}
+
+func newRoomUsers() *RoomUsers {
+	return &RoomUsers{users: map[string]*User{}}
+}
+
+func (u *RoomUsers) Get(userId string) (*User, bool) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	user, exist := u.users[userId]
+	return user, exist
+}
+
+func (u *RoomUsers) Set(userId string, user *User) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.users[userId] = user
+}
+
+func (u *RoomUsers) Remove(userId string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	delete(u.users, userId)
+}
+
+func (u *RoomUsers) Count() int {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return len(u.users)
+}
+
+// List is a copy of the users, in no order, users may join or quit while it is ranged
+func (u *RoomUsers) List() []*User {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	users := make([]*User, 0, len(u.users))
+	for _, user := range u.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+func (u *RoomUsers) MarshalJSON() ([]byte, error) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return json.Marshal(u.users)
+}
+
+type memoryRoomStore struct {
+	mutex sync.RWMutex
+	rooms map[string]*Room
+}
+
+func newMemoryRoomStore() *memoryRoomStore {
+	return &memoryRoomStore{rooms: map[string]*Room{}}
+}
+
+func (s *memoryRoomStore) Get(roomId string) (*Room, bool) {
+	if roomId == "" {
+		return nil, false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	room, exist := s.rooms[roomId]
+	return room, exist
+}
+
+func (s *memoryRoomStore) Save(room *Room) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rooms[room.RoomId] = room
+	return nil
+}
+
+func (s *memoryRoomStore) Remove(roomId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.rooms, roomId)
+	return nil
+}
+
+func (s *memoryRoomStore) List() []*Room {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (s *memoryRoomStore) Count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.rooms)
+}
+
+// memoryTopicStore swaps a copied map on every change so readers never lock
+type memoryTopicStore struct {
+	mutex  sync.Mutex
+	topics atomic.Value // map[string]*Topic
+}
+
+func newMemoryTopicStore() *memoryTopicStore {
+	s := &memoryTopicStore{}
+	s.topics.Store(map[string]*Topic{})
+	return s
+}
+
+func (s *memoryTopicStore) All() map[string]*Topic {
+	return s.topics.Load().(map[string]*Topic)
+}
+
+func (s *memoryTopicStore) Get(category string) (*Topic, bool) {
+	topic, exist := s.All()[category]
+	return topic, exist
+}
+
+func (s *memoryTopicStore) Has(category string) bool {
+	_, exist := s.All()[category]
+	return exist
+}
+
+func (s *memoryTopicStore) Categories() []string {
+	topics := s.All()
+	categories := make([]string, 0, len(topics))
+	for category := range topics {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// update copies the topics, changes the copy with fn and stores it
+func (s *memoryTopicStore) update(fn func(topics map[string]*Topic)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	topics := map[string]*Topic{}
+	for category, topic := range s.All() {
+		topics[category] = topic
+	}
+	fn(topics)
+	s.topics.Store(topics)
+}
+
+func (s *memoryTopicStore) Save(category string, topic *Topic) error {
+	s.update(func(topics map[string]*Topic) {
+		topics[category] = topic
+	})
+	return nil
+}
+
+func (s *memoryTopicStore) Rename(category string, newCategory string) error {
+	s.update(func(topics map[string]*Topic) {
+		if topic, exist := topics[category]; exist {
+			delete(topics, category)
+			topics[newCategory] = topic
+		}
+	})
+	return nil
+}
+
+func (s *memoryTopicStore) Remove(category string) error {
+	s.update(func(topics map[string]*Topic) {
+		delete(topics, category)
+	})
+	return nil
+}
+
+func (s *memoryTopicStore) Load(topics map[string]*Topic) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topics.Store(topics)
+}
+
+// memoryHistoryStore keeps the last historyLimit games
+type memoryHistoryStore struct {
+	mutex sync.RWMutex
+	games []*GameRecord // oldest first
+}
+
+func newMemoryHistoryStore() *memoryHistoryStore {
+	return &memoryHistoryStore{}
+}
+
+func (s *memoryHistoryStore) Add(game *GameRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.games = append(s.games, game)
+	if len(s.games) > historyLimit {
+		s.games = s.games[len(s.games)-historyLimit:]
+	}
+	return nil
+}
+
+func (s *memoryHistoryStore) Get(gameId string) (*GameRecord, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, game := range s.games {
+		if game.GameId == gameId {
+			return game, true
+		}
+	}
+	return nil, false
+}
+
+// find lists up to limit games matching fn, the newest first
+func (s *memoryHistoryStore) find(limit int, fn func(game *GameRecord) bool) []*GameRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	games := []*GameRecord{}
+	for i := len(s.games) - 1; i >= 0 && len(games) < limit; i-- {
+		if fn(s.games[i]) {
+			games = append(games, s.games[i])
+		}
+	}
+	return games
+}
+
+func (s *memoryHistoryStore) Recent(limit int) []*GameRecord {
+	return s.find(limit, func(game *GameRecord) bool {
+		return true
+	})
+}
+
+func (s *memoryHistoryStore) Each(fn func(game *GameRecord)) error {
+	s.mutex.RLock()
+	games := s.games
+	s.mutex.RUnlock()
+	for _, game := range games {
+		fn(game)
+	}
+	return nil
+}
+
+// ByPlayer lists the games of a user or of a player profile
+func (s *memoryHistoryStore) ByPlayer(userId string, limit int) []*GameRecord {
+	return s.find(limit, func(game *GameRecord) bool {
+		for _, player := range game.Players {
+			if player.UserId == userId || player.PlayerId == userId {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+type memoryProfileStore struct {
+	mutex    sync.Mutex
+	profiles map[string]*PlayerProfile
+}
+
+func newMemoryProfileStore() *memoryProfileStore {
+	return &memoryProfileStore{profiles: map[string]*PlayerProfile{}}
+}
+
+func copyProfile(profile *PlayerProfile) *PlayerProfile {
+	profileCopy := *profile
+	profileCopy.Result = nil
+	return &profileCopy
+}
+
+func (s *memoryProfileStore) Get(playerId string) (*PlayerProfile, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profile, exist := s.profiles[playerId]
+	if !exist {
+		return nil, false
+	}
+	return copyProfile(profile), true
+}
+
+func (s *memoryProfileStore) Save(profile *PlayerProfile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.profiles[profile.PlayerId] = copyProfile(profile)
+	return nil
+}
+
+func (s *memoryProfileStore) Update(playerId string, fn func(profile *PlayerProfile)) (*PlayerProfile, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profile, exist := s.profiles[playerId]
+	if !exist {
+		return nil, nil
+	}
+	fn(profile)
+	return copyProfile(profile), nil
+}
+
+type memoryStatStore struct {
+	mutex sync.Mutex
+	stats map[string]*TopicStat
+}
+
+func newMemoryStatStore() *memoryStatStore {
+	return &memoryStatStore{stats: map[string]*TopicStat{}}
+}
+
+func (s *memoryStatStore) Get(key string) (*TopicStat, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stat, exist := s.stats[key]
+	if !exist {
+		return nil, false
+	}
+	statCopy := *stat
+	return &statCopy, true
+}
+
+func (s *memoryStatStore) All() map[string]*TopicStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := make(map[string]*TopicStat, len(s.stats))
+	for key, stat := range s.stats {
+		statCopy := *stat
+		stats[key] = &statCopy
+	}
+	return stats
+}
+
+func (s *memoryStatStore) Update(key string, fn func(stat *TopicStat)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update(key, fn)
+	return nil
+}
+
+// update changes the stat of key, the caller holds the mutex
+func (s *memoryStatStore) update(key string, fn func(stat *TopicStat)) {
+	stat, exist := s.stats[key]
+	if !exist {
+		stat = &TopicStat{Key: key}
+		s.stats[key] = stat
+	}
+	fn(stat)
+}
+
+// saveRoom writes a room changed in place to the room store
+func saveRoom(room *Room) {
+	if err := roomStore.Save(room); err != nil {
+		roomLog(room.RoomId, "").error("room save fail", "err", err)
+	}
+}
+
+// allRooms is every room by roomId
+func allRooms() map[string]*Room {
+	rooms := map[string]*Room{}
+	for _, room := range roomStore.List() {
+		rooms[room.RoomId] = room
+	}
+	return rooms
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileTopicStore keeps the topics in memory and writes every change to
+// config.json and one json file per category in dir
+type fileTopicStore struct {
+	*memoryTopicStore
+	dir string
+}
+
+func newFileTopicStore(dir string) *fileTopicStore {
+	return &fileTopicStore{newMemoryTopicStore(), dir}
+}
+
+func (s *fileTopicStore) saveFile(fileName string, v interface{}) error {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.dir+fileName, jsonBytes)
+}
+
+// saveCategoryFile writes the topic of one category in the compact file format
+func (s *fileTopicStore) saveCategoryFile(category string, topic *Topic) error {
+	return s.saveFile(category+".json", compactTopic(topic))
+}
+
+// saveConfig writes config.json with the categories in the store
+func (s *fileTopicStore) saveConfig() error {
+	category := &Category{}
+	err := readJSONFile(s.dir+"config.json", category)
+	if err != nil {
+		category.Category = []string{}
+	}
+	// keep the order of the file, drop removed categories and append the new ones
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range category.Category {
+		if s.Has(name) && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	for _, name := range s.Categories() {
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	category.Category = names
+	return s.saveFile("config.json", category)
+}
+
+func (s *fileTopicStore) Save(category string, topic *Topic) error {
+	existed := s.Has(category)
+	if err := s.saveCategoryFile(category, topic); err != nil {
+		return err
+	}
+	s.memoryTopicStore.Save(category, topic)
+	if existed {
+		return nil
+	}
+	if err := s.saveConfig(); err != nil {
+		s.memoryTopicStore.Remove(category)
+		os.Remove(s.dir + category + ".json")
+		return err
+	}
+	return nil
+}
+
+func (s *fileTopicStore) Rename(category string, newCategory string) error {
+	topic, exist := s.Get(category)
+	if !exist {
+		return nil
+	}
+	if err := s.saveCategoryFile(newCategory, topic); err != nil {
+		return err
+	}
+	s.memoryTopicStore.Rename(category, newCategory)
+	if err := s.saveConfig(); err != nil {
+		s.memoryTopicStore.Rename(newCategory, category)
+		os.Remove(s.dir + newCategory + ".json")
+		return err
+	}
+	os.Remove(s.dir + category + ".json")
+	return nil
+}
+
+func (s *fileTopicStore) Remove(category string) error {
+	topic, exist := s.Get(category)
+	if !exist {
+		return nil
+	}
+	s.memoryTopicStore.Remove(category)
+	if err := s.saveConfig(); err != nil {
+		s.memoryTopicStore.Save(category, topic)
+		return err
+	}
+	os.Remove(s.dir + category + ".json")
+	return nil
+}
+
+// roomRecord is a room as written by fileRoomStore and the room snapshot, without the connections of its users
+type roomRecord struct {
+	RoomId           string        `json:"roomId"`
+	RoomName         string        `json:"roomName"`
+	Users            []*userRecord `json:"users"`
+	CurrentDrawOrder int           `json:"currentDrawOrder"`
+	NextDrawOrder    int           `json:"nextDrawOrder"`
+	TopicDetail      *TopicDetail  `json:"topicDetail,omitempty"`
+	HostUserId       string        `json:"hostUserId,omitempty"`
+	CustomWords      []string      `json:"customWords,omitempty"`
+	CustomWordsMode  string        `json:"customWordsMode,omitempty"`
+	Locale           string        `json:"locale,omitempty"`
+	Difficulty       string        `json:"difficulty,omitempty"`
+	Packs            []string      `json:"packs,omitempty"`
+	TopicKey         string        `json:"topicKey,omitempty"`
+	Answers          []string      `json:"answers,omitempty"`
+	RoundStartedAt   time.Time     `json:"roundStartedAt,omitempty"`
+	FirstGuessAt     time.Time     `json:"firstGuessAt,omitempty"`
+	Guessed          []string      `json:"guessed,omitempty"` // users guessed correctly this round
+	Game             *GameRecord   `json:"game,omitempty"`    // the game in progress
+}
+
+type userRecord struct {
+	UserId    string `json:"userId"`
+	UserName  string `json:"userName"`
+	DrawOrder int    `json:"drawOrder"`
+	Ready     *bool  `json:"ready,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Score     int    `json:"score"`
+	PlayerId  string `json:"playerId,omitempty"`
+	UserToken string `json:"userToken,omitempty"`
+}
+
+// newRoomRecord copies the room under its mutex, the record can be marshaled while the game goes on
+func newRoomRecord(room *Room) *roomRecord {
+	room.mutex.Lock()
+	record := &roomRecord{RoomId: room.RoomId, RoomName: room.RoomName, Users: []*userRecord{},
+		CurrentDrawOrder: room.CurrentDrawOrder, NextDrawOrder: room.NextDrawOrder,
+		HostUserId: room.HostUserId, CustomWords: room.CustomWords, CustomWordsMode: room.CustomWordsMode,
+		Locale: room.Locale, Difficulty: room.Difficulty, Packs: room.Packs,
+		TopicKey: room.round.key, Answers: room.round.answers, RoundStartedAt: room.round.startedAt,
+		FirstGuessAt: room.round.firstGuessAt, Game: copyGame(room.game)}
+	if room.TopicDetail != nil {
+		topicDetail := *room.TopicDetail
+		record.TopicDetail = &topicDetail
+	}
+	for userId := range room.round.guessed {
+		record.Guessed = append(record.Guessed, userId)
+	}
+	room.mutex.Unlock()
+	for _, user := range room.Users.List() {
+		record.Users = append(record.Users, &userRecord{user.UserId, user.UserName, user.DrawOrder, user.Ready, user.Role, user.Score, user.PlayerId, user.UserToken})
+	}
+	return record
+}
+
+func (record *roomRecord) room() *Room {
+	room := &Room{RoomId: record.RoomId, RoomName: record.RoomName, Users: newRoomUsers(),
+		CurrentDrawOrder: record.CurrentDrawOrder, NextDrawOrder: record.NextDrawOrder, TopicDetail: record.TopicDetail,
+		HostUserId: record.HostUserId, CustomWords: record.CustomWords, CustomWordsMode: record.CustomWordsMode,
+		Locale: record.Locale, Difficulty: record.Difficulty, Packs: record.Packs, game: record.Game}
+	if room.TopicDetail == nil {
+		room.TopicDetail = &TopicDetail{}
+	}
+	room.round.key = record.TopicKey
+	room.round.answers = record.Answers
+	room.round.startedAt = record.RoundStartedAt
+	room.round.firstGuessAt = record.FirstGuessAt
+	room.round.guesses = len(record.Guessed)
+	if len(record.Guessed) > 0 {
+		room.round.guessed = map[string]bool{}
+		for _, userId := range record.Guessed {
+			room.round.guessed[userId] = true
+		}
+	}
+	for _, userRecord := range record.Users {
+		room.Users.Set(userRecord.UserId, &User{RoomId: room.RoomId, UserId: userRecord.UserId, UserName: userRecord.UserName,
+			DrawOrder: userRecord.DrawOrder, Ready: userRecord.Ready, Role: userRecord.Role, Score: userRecord.Score, PlayerId: userRecord.PlayerId,
+			UserToken: userRecord.UserToken})
+	}
+	return room
+}
+
+// fileRoomStore keeps the rooms in memory and writes each room to dir/roomId.json,
+// the rooms in dir are read back when the store is opened
+type fileRoomStore struct {
+	*memoryRoomStore
+	dir   string
+	mutex sync.Mutex // serialize the writes of the room files
+}
+
+func newFileRoomStore(dir string) (*fileRoomStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &fileRoomStore{memoryRoomStore: newMemoryRoomStore(), dir: dir}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		record := &roomRecord{}
+		if err := readJSONFile(dir+file.Name(), record); err != nil || record.RoomId == "" {
+			appLog.warn("room file fail, skip it", "file", file.Name())
+			continue
+		}
+		s.memoryRoomStore.Save(record.room())
+	}
+	return s, nil
+}
+
+// Save copies and writes the room under the mutex, two saves of a room can not write
+// their records in the other order and leave the older one on disk
+func (s *fileRoomStore) Save(room *Room) error {
+	s.memoryRoomStore.Save(room)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exist := s.Get(room.RoomId); !exist { // removed meanwhile
+		return nil
+	}
+	jsonBytes, err := json.Marshal(newRoomRecord(room))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.dir+room.RoomId+".json", jsonBytes)
+}
+
+func (s *fileRoomStore) Remove(roomId string) error {
+	s.memoryRoomStore.Remove(roomId)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := os.Remove(s.dir + roomId + ".json")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fileHistoryStore appends each finished game as a json line to fileName,
+// the last historyLimit games are read back when the store is opened
+type fileHistoryStore struct {
+	*memoryHistoryStore
+	fileName string
+	file     *os.File
+}
+
+func newFileHistoryStore(fileName string) (*fileHistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileHistoryStore{newMemoryHistoryStore(), fileName, file}
+	err = s.Each(func(game *GameRecord) {
+		s.memoryHistoryStore.Add(game)
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Each reads every game in the file, not only the last historyLimit games
+func (s *fileHistoryStore) Each(fn func(game *GameRecord)) error {
+	file, err := os.Open(s.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		game := &GameRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), game); err != nil {
+			appLog.warn("history line fail, skip it", "err", err)
+			continue
+		}
+		fn(game)
+	}
+	return scanner.Err()
+}
+
+func (s *fileHistoryStore) Add(game *GameRecord) error {
+	jsonBytes, err := json.Marshal(game)
+	if err != nil {
+		return err
+	}
+	s.memoryHistoryStore.Add(game)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(jsonBytes, '\n'))
+	return err
+}
+
+// fileProfileStore writes every profile to fileName after each change
+type fileProfileStore struct {
+	*memoryProfileStore
+	fileName string
+}
+
+func newFileProfileStore(fileName string) (*fileProfileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	s := &fileProfileStore{newMemoryProfileStore(), fileName}
+	err := readJSONFile(fileName, &s.profiles)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.profiles == nil {
+		s.profiles = map[string]*PlayerProfile{}
+	}
+	return s, nil
+}
+
+// saveFile writes the profiles, the caller holds the mutex
+func (s *fileProfileStore) saveFile() error {
+	jsonBytes, err := json.Marshal(s.profiles)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.fileName, jsonBytes)
+}
+
+func (s *fileProfileStore) Save(profile *PlayerProfile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.profiles[profile.PlayerId] = copyProfile(profile)
+	return s.saveFile()
+}
+
+func (s *fileProfileStore) Update(playerId string, fn func(profile *PlayerProfile)) (*PlayerProfile, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profile, exist := s.profiles[playerId]
+	if !exist {
+		return nil, nil
+	}
+	fn(profile)
+	return copyProfile(profile), s.saveFile()
+}
+
+// topicStatRecord is a topic statistic as written by fileStatStore, the rates are computed again on read
+type topicStatRecord struct {
+	Rounds            int     `json:"rounds"`
+	GuessedRounds     int     `json:"guessedRounds"`
+	CorrectGuesses    int     `json:"correctGuesses"`
+	FirstGuessSeconds float64 `json:"firstGuessSeconds"` // total of the guessed rounds
+}
+
+// fileStatStore writes every topic statistic to fileName after each change
+type fileStatStore struct {
+	*memoryStatStore
+	fileName string
+}
+
+func newFileStatStore(fileName string) (*fileStatStore, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	s := &fileStatStore{newMemoryStatStore(), fileName}
+	records := map[string]*topicStatRecord{}
+	err := readJSONFile(fileName, &records)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for key, record := range records {
+		s.update(key, func(stat *TopicStat) {
+			stat.Rounds = record.Rounds
+			stat.GuessedRounds = record.GuessedRounds
+			stat.CorrectGuesses = record.CorrectGuesses
+			stat.firstGuessTotal = time.Duration(record.FirstGuessSeconds * float64(time.Second))
+			computeTopicStat(stat)
+		})
+	}
+	return s, nil
+}
+
+func (s *fileStatStore) Update(key string, fn func(stat *TopicStat)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update(key, fn)
+	records := make(map[string]*topicStatRecord, len(s.stats))
+	for key, stat := range s.stats {
+		records[key] = &topicStatRecord{stat.Rounds, stat.GuessedRounds, stat.CorrectGuesses, stat.firstGuessTotal.Seconds()}
+	}
+	jsonBytes, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.fileName, jsonBytes)
+}
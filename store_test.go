@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// eachBackend runs test with the stores opened by openStores in a new dir, once with
+// the memory backend and once with the file backend. reopen opens the file stores again
+// as after a restart, the memory stores are kept as they are.
+func eachBackend(t *testing.T, test func(t *testing.T, reopen func())) {
+	defer func(data string, topic string, types []string) {
+		dataDir, topicDir = data, topic
+		roomStoreType, topicStoreType, historyStoreType, profileStoreType, statStoreType = types[0], types[1], types[2], types[3], types[4]
+	}(dataDir, topicDir, []string{roomStoreType, topicStoreType, historyStoreType, profileStoreType, statStoreType})
+	for _, backend := range []string{storeMemory, storeFile} {
+		t.Run(backend, func(t *testing.T) {
+			dataDir = t.TempDir() + "/"
+			topicDir = dataDir
+			roomStoreType, topicStoreType, historyStoreType, profileStoreType, statStoreType = backend, backend, backend, backend, backend
+			open := func() {
+				if store, ok := historyStore.(*fileHistoryStore); ok {
+					store.file.Close()
+				}
+				if err := openStores(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			open()
+			defer func() {
+				if store, ok := historyStore.(*fileHistoryStore); ok {
+					store.file.Close()
+				}
+			}()
+			test(t, func() {
+				if backend == storeFile {
+					open()
+				}
+			})
+		})
+	}
+}
+
+func TestRoomStore(t *testing.T) {
+	eachBackend(t, func(t *testing.T, reopen func()) {
+		room := &Room{RoomId: "r1", RoomName: "room", Users: newRoomUsers(), TopicDetail: &TopicDetail{}, Locale: "en"}
+		room.Users.Set("u1", &User{RoomId: "r1", UserId: "u1", UserName: "Ann", Score: 12})
+		startedAt := time.Now().Add(-time.Minute).Round(time.Second)
+		room.round = roundState{key: "animal/cat", answers: []string{"cat"}, startedAt: startedAt,
+			firstGuessAt: startedAt.Add(20 * time.Second), guessed: map[string]bool{"u1": true}, guesses: 1}
+		if err := roomStore.Save(room); err != nil {
+			t.Fatal(err)
+		}
+		if err := roomStore.Save(&Room{RoomId: "r2", RoomName: "other", Users: newRoomUsers()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := roomStore.Remove("r2"); err != nil {
+			t.Fatal(err)
+		}
+		reopen()
+		if roomStore.Count() != 1 || len(roomStore.List()) != 1 {
+			t.Fatalf("count %d, want 1", roomStore.Count())
+		}
+		got, exist := roomStore.Get("r1")
+		if !exist || got.RoomName != "room" || got.Locale != "en" {
+			t.Fatalf("room %+v", got)
+		}
+		user, exist := got.Users.Get("u1")
+		if !exist || user.Score != 12 {
+			t.Fatalf("user u1 is not restored")
+		}
+		if got.round.key != "animal/cat" || !got.round.guessed["u1"] || got.round.firstGuessAt.Sub(got.round.startedAt) != 20*time.Second {
+			t.Fatalf("round %+v", got.round)
+		}
+		if _, exist := roomStore.Get("r2"); exist {
+			t.Fatal("removed room r2 exists")
+		}
+	})
+}
+
+func TestTopicStore(t *testing.T) {
+	eachBackend(t, func(t *testing.T, reopen func()) {
+		for _, category := range []string{"animal", "food", "tools"} {
+			if err := topicStore.Save(category, normalizeTopic(&Topic{Topics: []string{category + "1", category + "2"}})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := topicStore.Rename("food", "snack"); err != nil {
+			t.Fatal(err)
+		}
+		if err := topicStore.Remove("tools"); err != nil {
+			t.Fatal(err)
+		}
+		reopen()
+		if topicStoreType == storeFile { // the topic files are read at startup by loading
+			loadTopics()
+		}
+		categories := topicStore.Categories()
+		if len(categories) != 2 || categories[0] != "animal" || categories[1] != "snack" {
+			t.Fatalf("categories %v, want [animal snack]", categories)
+		}
+		topic, exist := topicStore.Get("snack")
+		if !exist || len(topic.Words) != 2 {
+			t.Fatalf("snack %+v", topic)
+		}
+		if topicStore.Has("food") || topicStore.Has("tools") {
+			t.Fatal("renamed or removed category exists")
+		}
+	})
+}
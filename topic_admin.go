@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var topicDir = "sample/topic/" // config.json and one json file per category
+var adminToken = ""            // empty means the admin api is disabled
+
+var topicsMutex sync.Mutex // serialize the changes of topics and its files
+
+// CategoryBean is the request and response of the topic admin api
+type CategoryBean struct {
+	Category string   `json:"category,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+	Result   *bool    `json:"result,omitempty"`
+}
+
+var (
+	errUnauthorized     = &apiError{http.StatusUnauthorized, "unauthorized", "admin token is missing or wrong"}
+	errAdminDisabled    = &apiError{http.StatusForbidden, "admin_disabled", "admin api is disabled, set ADMIN_TOKEN to enable it"}
+	errInvalidCategory  = &apiError{http.StatusUnprocessableEntity, "invalid_category", "category name is empty or not a valid file name"}
+	errInvalidTopic     = &apiError{http.StatusUnprocessableEntity, "invalid_topic", "topic is empty"}
+	errCategoryNotFound = &apiError{http.StatusNotFound, "category_not_found", "category is not exist"}
+	errCategoryExist    = &apiError{http.StatusConflict, "category_exist", "category is already exist"}
+	errStorage          = &apiError{http.StatusInternalServerError, "storage_error", "fail to write topic files"}
+)
+
+// checkAdmin writes the error and returns false if the request has no admin token
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		writeAPIError(w, errAdminDisabled)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+adminToken)) != 1 {
+		writeAPIError(w, errUnauthorized)
+		return false
+	}
+	return true
+}
+
+// adminRoute wraps a handler of the /api/v1/admin routes with checkAdmin
+func adminRoute(handler func(w http.ResponseWriter, r *http.Request, params map[string]string)) func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if !checkAdmin(w, r) {
+			return
+		}
+		handler(w, r, params)
+	}
+}
+
+func validCategoryName(category string) bool {
+	if category == "" || category == "config" || category == "." || category == ".." {
+		return false
+	}
+	return !strings.ContainsAny(category, `/\`)
+}
+
+// writeFileAtomic writes data to a temp file in the same directory then renames it,
+// so a crash never leaves a half written file
+func writeFileAtomic(fileName string, data []byte) error {
+	dir := filepath.Dir(fileName)
+	tmpFile, err := ioutil.TempFile(dir, ".tmp-"+filepath.Base(fileName))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileName)
+}
+
+func getTopic(category string) (*Topic, bool) {
+	return topicStore.Get(category)
+}
+
+func createCategory(bean *CategoryBean) *apiError {
+	if !validCategoryName(bean.Category) {
+		return errInvalidCategory
+	}
+	for _, topic := range bean.Topics {
+		if strings.TrimSpace(topic) == "" {
+			return errInvalidTopic
+		}
+	}
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	if topicStore.Has(bean.Category) {
+		return errCategoryExist
+	}
+	topic := normalizeTopic(&Topic{Topics: uniqueTopics(nil, bean.Topics)})
+	if err := topicStore.Save(bean.Category, topic); err != nil {
+		appLog.error("topic store fail", "err", err)
+		return errStorage
+	}
+	bean.Topics = topic.Topics
+	return nil
+}
+
+func renameCategory(category string, newCategory string) *apiError {
+	if !validCategoryName(newCategory) {
+		return errInvalidCategory
+	}
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	if !topicStore.Has(category) {
+		return errCategoryNotFound
+	}
+	if category == newCategory {
+		return nil
+	}
+	if topicStore.Has(newCategory) {
+		return errCategoryExist
+	}
+	if err := topicStore.Rename(category, newCategory); err != nil {
+		appLog.error("topic store fail", "err", err)
+		return errStorage
+	}
+	return nil
+}
+
+func deleteCategory(category string) *apiError {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	if !topicStore.Has(category) {
+		return errCategoryNotFound
+	}
+	if err := topicStore.Remove(category); err != nil {
+		appLog.error("topic store fail", "err", err)
+		return errStorage
+	}
+	return nil
+}
+
+// updateTopics adds or removes defaultLocale topics of a category, the topic is replaced as a whole
+// so a game reading the old one is not affected
+func updateTopics(category string, add []string, remove []string) (*Topic, *apiError) {
+	for _, topic := range add {
+		if strings.TrimSpace(topic) == "" {
+			return nil, errInvalidTopic
+		}
+	}
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	topic, exist := getTopic(category)
+	if !exist {
+		return nil, errCategoryNotFound
+	}
+	removed := map[string]bool{}
+	for _, item := range remove {
+		removed[item] = true
+	}
+	kept := &Topic{}
+	for _, word := range topic.Words {
+		localeWord := word.Locales[defaultLocale]
+		if localeWord == nil || !removed[localeWord.Word] {
+			kept.Words = append(kept.Words, word)
+		}
+	}
+	kept.Topics = uniqueTopics(nil, add)
+	newTopic := normalizeTopic(kept)
+	if err := topicStore.Save(category, newTopic); err != nil {
+		appLog.error("topic store fail", "err", err)
+		return nil, errStorage
+	}
+	return newTopic, nil
+}
+
+func uniqueTopics(topicList []string, add []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, list := range [][]string{topicList, add} {
+		for _, item := range list {
+			item = strings.TrimSpace(item)
+			if !seen[item] {
+				result = append(result, item)
+				seen[item] = true
+			}
+		}
+	}
+	return result
+}
+
+func apiCategoryCreateHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &CategoryBean{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	if apiErr := createCategory(bean); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	result := true
+	bean.Result = &result
+	writeJSON(w, http.StatusCreated, bean)
+}
+
+func apiCategoryRenameHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &CategoryBean{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	if apiErr := renameCategory(params["category"], bean.Category); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	topic, _ := getTopic(bean.Category)
+	result := true
+	writeJSON(w, http.StatusOK, CategoryBean{bean.Category, topic.Topics, &result})
+}
+
+func apiCategoryDeleteHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if apiErr := deleteCategory(params["category"]); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiTopicAddHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &Topic{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	topic, apiErr := updateTopics(params["category"], bean.Topics, nil)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	result := true
+	writeJSON(w, http.StatusOK, CategoryBean{params["category"], topic.Topics, &result})
+}
+
+func apiTopicRemoveHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	topic, apiErr := updateTopics(params["category"], nil, []string{params["topic"]})
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	result := true
+	writeJSON(w, http.StatusOK, CategoryBean{params["category"], topic.Topics, &result})
+}
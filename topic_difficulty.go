@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	difficultyEasy   = "easy"
+	difficultyMedium = "medium"
+	difficultyHard   = "hard"
+	difficultyMixed  = "mixed" // room setting, any difficulty
+)
+
+var minRoundsToAdjust = 10 // rounds played before the statistics override the rated difficulty
+
+// TopicStat is the statistics of one topic from the rounds played
+type TopicStat struct {
+	Key                  string  `json:"key"`
+	Rounds               int     `json:"rounds"`
+	GuessedRounds        int     `json:"guessedRounds"`
+	CorrectGuesses       int     `json:"correctGuesses"`
+	GuessRate            float64 `json:"guessRate"`
+	AvgFirstGuessSeconds float64 `json:"avgFirstGuessSeconds"`
+	RatedDifficulty      string  `json:"ratedDifficulty,omitempty"`
+	Difficulty           string  `json:"difficulty"` // adjusted by the statistics
+
+	firstGuessTotal time.Duration
+}
+
+// roundState is the topic of the current round of a room and its guesses
+type roundState struct {
+	key          string
+	answers      []string
+	startedAt    time.Time
+	firstGuessAt time.Time
+	guesses      int
+	guessed      map[string]bool // users guessed correctly, each scores once
+}
+
+func validRoomDifficulty(difficulty string) bool {
+	return difficulty == difficultyEasy || difficulty == difficultyMedium ||
+		difficulty == difficultyHard || difficulty == difficultyMixed
+}
+
+// topicKey identifies a topic in every locale, category/defaultLocale word
+func topicKey(category string, word *TopicWord) string {
+	if localeWord := word.Locales[defaultLocale]; localeWord != nil {
+		return category + "/" + localeWord.Word
+	}
+	locales := make([]string, 0, len(word.Locales))
+	for locale := range word.Locales {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return category + "/" + locales[0] + ":" + word.Locales[locales[0]].Word
+}
+
+func ratedDifficulty(word *TopicWord) string {
+	if word.Difficulty == "" {
+		return difficultyMedium
+	}
+	return word.Difficulty
+}
+
+// adjustedDifficulty is the rated difficulty until the topic has enough rounds,
+// then it follows how often and how fast the topic is guessed
+func adjustedDifficulty(stat *TopicStat, rated string) string {
+	if stat == nil || stat.Rounds < minRoundsToAdjust {
+		return rated
+	}
+	if stat.GuessRate >= 0.8 && stat.AvgFirstGuessSeconds <= 30 {
+		return difficultyEasy
+	}
+	if stat.GuessRate < 0.4 {
+		return difficultyHard
+	}
+	return difficultyMedium
+}
+
+func matchDifficulty(key string, word *TopicWord, difficulty string) bool {
+	if difficulty == "" || difficulty == difficultyMixed {
+		return true
+	}
+	stat, _ := statStore.Get(key)
+	return adjustedDifficulty(stat, ratedDifficulty(word)) == difficulty
+}
+
+// startRound ends the last round of the room and starts a new one with picked,
+// it returns the ended round for recordRound
+func startRound(room *Room, picked *pickedTopic) roundState {
+	ended := endRound(room)
+	room.round = roundState{key: picked.Key, answers: picked.Answers, startedAt: time.Now()}
+	return ended
+}
+
+func recordCorrectGuess(room *Room) {
+	if room.round.key == "" {
+		return
+	}
+	if room.round.guesses == 0 {
+		room.round.firstGuessAt = time.Now()
+	}
+	room.round.guesses++
+}
+
+// endRound takes the current round off the room, it runs under the room mutex
+// and the caller adds the round to the topic statistics with recordRound after unlocking
+func endRound(room *Room) roundState {
+	round := room.round
+	room.round = roundState{}
+	return round
+}
+
+// recordRound adds a round taken by endRound to the topic statistics
+func recordRound(round roundState) {
+	if round.key == "" {
+		return
+	}
+	err := statStore.Update(round.key, func(stat *TopicStat) {
+		stat.Rounds++
+		stat.CorrectGuesses += round.guesses
+		if round.guesses > 0 {
+			stat.GuessedRounds++
+			if !round.firstGuessAt.IsZero() { // a round restored from a snapshot without it
+				stat.firstGuessTotal += round.firstGuessAt.Sub(round.startedAt)
+			}
+		}
+		computeTopicStat(stat)
+	})
+	if err != nil {
+		appLog.error("topic stat save fail", "key", round.key, "err", err)
+	}
+}
+
+// computeTopicStat sets the rates of stat from its counts
+func computeTopicStat(stat *TopicStat) {
+	if stat.GuessedRounds > 0 {
+		stat.AvgFirstGuessSeconds = stat.firstGuessTotal.Seconds() / float64(stat.GuessedRounds)
+	}
+	if stat.Rounds > 0 {
+		stat.GuessRate = float64(stat.GuessedRounds) / float64(stat.Rounds)
+	}
+}
+
+// listTopicStats lists every topic in the catalog with its statistics
+func listTopicStats() []TopicStat {
+	recorded := statStore.All()
+	stats := []TopicStat{}
+	for category, topic := range topicStore.All() {
+		for _, word := range topic.Words {
+			key := topicKey(category, word)
+			stat := TopicStat{Key: key}
+			if recordedStat, exist := recorded[key]; exist {
+				stat = *recordedStat
+			}
+			stat.RatedDifficulty = ratedDifficulty(word)
+			stat.Difficulty = adjustedDifficulty(&stat, stat.RatedDifficulty)
+			stats = append(stats, stat)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return strings.Compare(stats[i].Key, stats[j].Key) < 0
+	})
+	return stats
+}
+
+func apiTopicStatsHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, listTopicStats())
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatStore(t *testing.T) {
+	eachBackend(t, func(t *testing.T, reopen func()) {
+		for _, guessed := range []bool{true, false} {
+			err := statStore.Update("animal/cat", func(stat *TopicStat) {
+				stat.Rounds++
+				if guessed {
+					stat.GuessedRounds++
+					stat.CorrectGuesses += 2
+					stat.firstGuessTotal += 10 * time.Second
+				}
+				computeTopicStat(stat)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		reopen()
+		stat, exist := statStore.Get("animal/cat")
+		if !exist || stat.Rounds != 2 || stat.CorrectGuesses != 2 || stat.GuessRate != 0.5 || stat.AvgFirstGuessSeconds != 10 {
+			t.Fatalf("stat %+v", stat)
+		}
+		if _, exist := statStore.Get("animal/dog"); exist {
+			t.Fatal("animal/dog exists")
+		}
+		if stats := statStore.All(); len(stats) != 1 {
+			t.Fatalf("all %d, want 1", len(stats))
+		}
+	})
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// rows of the same category and id are one word in several locales
+var csvHeader = []string{"category", "word", "aliases", "difficulty", "locale", "id"}
+
+// TopicBundle is the json import and export format, the topics by category
+type TopicBundle struct {
+	Categories map[string]*Topic `json:"categories"`
+}
+
+// ImportReport tells what an import added, skipped as duplicate or rejected
+type ImportReport struct {
+	DryRun     bool     `json:"dryRun"`
+	Applied    bool     `json:"applied"`
+	Categories []string `json:"categories,omitempty"` // categories created
+	Words      int      `json:"words"`                // words added
+	Duplicates []string `json:"duplicates,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+var maxImportBytes = 5 * 1024 * 1024 // of an import request body
+
+var (
+	errInvalidFormat  = &apiError{http.StatusBadRequest, "invalid_format", "format must be csv or json"}
+	errImportTooLarge = &apiError{http.StatusRequestEntityTooLarge, "import_too_large", "import is too large"}
+)
+
+func formatOf(fileName string, format string) string {
+	if format != "" {
+		return format
+	}
+	if strings.HasSuffix(strings.ToLower(fileName), ".csv") {
+		return formatCSV
+	}
+	return formatJSON
+}
+
+// parseCSVBundle reads rows of category,word,aliases,difficulty[,locale,id],
+// aliases are separated by '|' and the header row is optional
+func parseCSVBundle(reader io.Reader) (*TopicBundle, []string) {
+	bundle := &TopicBundle{map[string]*Topic{}}
+	errors := []string{}
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return bundle, []string{err.Error()}
+	}
+	columns := map[string]int{"category": 0, "word": 1, "aliases": 2, "difficulty": 3, "locale": 4, "id": 5}
+	wordsById := map[string]*TopicWord{}
+	start := 0
+	if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), "category") {
+		columns = map[string]int{}
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		start = 1
+	}
+	field := func(record []string, name string) string {
+		i, exist := columns[name]
+		if !exist || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	for line := start; line < len(records); line++ {
+		record := records[line]
+		category := field(record, "category")
+		word := field(record, "word")
+		if category == "" && word == "" {
+			continue
+		}
+		if category == "" || word == "" {
+			errors = append(errors, fmt.Sprintf("line %d: category and word are required", line+1))
+			continue
+		}
+		locale := field(record, "locale")
+		if locale == "" {
+			locale = defaultLocale
+		}
+		localeWord := &LocaleWord{Word: word}
+		for _, alias := range strings.Split(field(record, "aliases"), "|") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				localeWord.Aliases = append(localeWord.Aliases, alias)
+			}
+		}
+		topic, exist := bundle.Categories[category]
+		if !exist {
+			topic = &Topic{}
+			bundle.Categories[category] = topic
+		}
+		id := field(record, "id")
+		if word, exist := wordsById[category+"\x00"+id]; exist && id != "" {
+			if _, exist := word.Locales[locale]; exist {
+				errors = append(errors, fmt.Sprintf("line %d: id %s has two %s words", line+1, id, locale))
+				continue
+			}
+			word.Locales[locale] = localeWord
+			continue
+		}
+		topicWord := &TopicWord{
+			Locales:    map[string]*LocaleWord{locale: localeWord},
+			Difficulty: field(record, "difficulty"),
+		}
+		if id != "" {
+			wordsById[category+"\x00"+id] = topicWord
+		}
+		topic.Words = append(topic.Words, topicWord)
+	}
+	return bundle, errors
+}
+
+func parseBundle(data []byte, format string) (*TopicBundle, []string) {
+	if format == formatCSV {
+		return parseCSVBundle(bytes.NewReader(data))
+	}
+	bundle := &TopicBundle{}
+	err := json.Unmarshal(data, bundle)
+	if err != nil {
+		return bundle, []string{err.Error()}
+	}
+	if bundle.Categories == nil {
+		return bundle, []string{`bundle has no "categories"`}
+	}
+	return bundle, nil
+}
+
+// wordLocaleKeys identify a word for duplicate detection, one key per locale
+func wordLocaleKeys(word *TopicWord) []string {
+	keys := []string{}
+	for locale, localeWord := range word.Locales {
+		keys = append(keys, locale+":"+normalizeAnswer(localeWord.Word))
+	}
+	return keys
+}
+
+// importBundle validates the bundle and merges it into topics and their files,
+// nothing is written if dryRun or any row is invalid, and a failed save
+// rolls the categories already saved back
+func importBundle(bundle *TopicBundle, parseErrors []string, dryRun bool) *ImportReport {
+	report := &ImportReport{DryRun: dryRun, Errors: parseErrors}
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+
+	categories := make([]string, 0, len(bundle.Categories))
+	for category := range bundle.Categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	changed := map[string]*Topic{}
+	for _, category := range categories {
+		imported := bundle.Categories[category]
+		if !validCategoryName(category) {
+			report.Errors = append(report.Errors, category+": category name is not a valid file name")
+			continue
+		}
+		if imported == nil {
+			continue
+		}
+		if hasEmptyTopic(imported) || hasInvalidDifficulty(imported) {
+			report.Errors = append(report.Errors, category+": has an empty word, an invalid locale or difficulty")
+			continue
+		}
+		imported = normalizeTopic(imported)
+
+		merged := &Topic{}
+		seen := map[string]bool{}
+		if current, exist := getTopic(category); exist {
+			merged.Words = append(merged.Words, current.Words...)
+			for _, word := range current.Words {
+				for _, key := range wordLocaleKeys(word) {
+					seen[key] = true
+				}
+			}
+		} else {
+			report.Categories = append(report.Categories, category)
+		}
+		for _, word := range imported.Words {
+			duplicate := false
+			for _, key := range wordLocaleKeys(word) {
+				if seen[key] {
+					duplicate = true
+					report.Duplicates = append(report.Duplicates, category+"/"+strings.SplitN(key, ":", 2)[1])
+					break
+				}
+			}
+			if duplicate {
+				continue
+			}
+			for _, key := range wordLocaleKeys(word) {
+				seen[key] = true
+			}
+			merged.Words = append(merged.Words, word)
+			report.Words++
+		}
+		changed[category] = normalizeTopic(merged)
+	}
+	if dryRun || len(report.Errors) > 0 {
+		return report
+	}
+
+	saved := []string{}
+	previous := map[string]*Topic{} // nil for a category the import created
+	for _, category := range categories {
+		topic, exist := changed[category]
+		if !exist {
+			continue
+		}
+		previous[category], _ = getTopic(category)
+		if err := topicStore.Save(category, topic); err != nil {
+			report.Errors = append(report.Errors, category+": "+err.Error())
+			rollbackImport(saved, previous)
+			return report
+		}
+		saved = append(saved, category)
+	}
+	report.Applied = true
+	return report
+}
+
+// rollbackImport puts the saved categories back as they were before the import
+func rollbackImport(saved []string, previous map[string]*Topic) {
+	for i := len(saved) - 1; i >= 0; i-- {
+		category := saved[i]
+		var err error
+		if previous[category] == nil {
+			err = topicStore.Remove(category)
+		} else {
+			err = topicStore.Save(category, previous[category])
+		}
+		if err != nil {
+			appLog.error("topic import rollback fail", "category", category, "err", err)
+		}
+	}
+}
+
+// exportBundle writes the whole catalog as csv or json
+func exportBundle(writer io.Writer, format string) error {
+	categories := topicStore.Categories()
+	if format == formatJSON {
+		bundle := &TopicBundle{map[string]*Topic{}}
+		for _, category := range categories {
+			if topic, exist := getTopic(category); exist {
+				bundle.Categories[category] = compactTopic(topic)
+			}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(bundle)
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write(csvHeader)
+	for _, category := range categories {
+		topic, exist := getTopic(category)
+		if !exist {
+			continue
+		}
+		for _, word := range topic.Words {
+			locales := make([]string, 0, len(word.Locales))
+			for locale := range word.Locales {
+				locales = append(locales, locale)
+			}
+			sort.Strings(locales)
+			id := topicKey(category, word)
+			for _, locale := range locales {
+				localeWord := word.Locales[locale]
+				csvWriter.Write([]string{category, localeWord.Word, strings.Join(localeWord.Aliases, "|"), word.Difficulty, locale, id})
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// runTopicCommand runs -import or -export on the files of topicDir
+func runTopicCommand(importFile string, exportFile string, format string, dryRun bool) error {
+	if format != "" && format != formatCSV && format != formatJSON {
+		return fmt.Errorf("-format must be csv or json, not %q", format)
+	}
+	report := reloadTopics()
+	if !report.Loaded {
+		return fmt.Errorf("topic files in %s are not valid", topicDir)
+	}
+	if importFile != "" {
+		data, err := ioutil.ReadFile(importFile)
+		if err != nil {
+			return err
+		}
+		bundle, parseErrors := parseBundle(data, formatOf(importFile, format))
+		importReport := importBundle(bundle, parseErrors, dryRun)
+		jsonBytes, _ := json.MarshalIndent(importReport, "", "  ")
+		fmt.Println(string(jsonBytes))
+		if len(importReport.Errors) > 0 {
+			return fmt.Errorf("import %s fail", importFile)
+		}
+		return nil
+	}
+	if exportFile == "-" {
+		return exportBundle(os.Stdout, formatOf(exportFile, format))
+	}
+	var buf bytes.Buffer
+	err := exportBundle(&buf, formatOf(exportFile, format))
+	if err != nil {
+		return err
+	}
+	appLog.info("export topics", "file", filepath.Clean(exportFile))
+	return ioutil.WriteFile(exportFile, buf.Bytes(), 0644)
+}
+
+func apiTopicImportHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatJSON
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = formatCSV
+		}
+	}
+	if format != formatCSV && format != formatJSON {
+		writeAPIError(w, errInvalidFormat)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxImportBytes)))
+	if err != nil {
+		writeAPIError(w, errImportTooLarge)
+		return
+	}
+	bundle, parseErrors := parseBundle(data, format)
+	report := importBundle(bundle, parseErrors, r.URL.Query().Get("dryRun") == "true")
+	if len(report.Errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func apiTopicExportHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatJSON
+	}
+	if format != formatCSV && format != formatJSON {
+		writeAPIError(w, errInvalidFormat)
+		return
+	}
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=topics."+format)
+	if err := exportBundle(w, format); err != nil {
+		appLog.warn("topic export fail", "err", err)
+	}
+}
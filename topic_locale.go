@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+)
+
+var defaultLocale = "zh-TW" // locale of the plain "topics" lists and of rooms without one
+
+// TopicWord is one topic in several locales
+type TopicWord struct {
+	Locales    map[string]*LocaleWord `json:"locales"`
+	Difficulty string                 `json:"difficulty,omitempty"` // easy, medium or hard, medium if empty
+}
+
+// LocaleWord is the word of a topic in one locale with the other accepted answers
+type LocaleWord struct {
+	Word    string   `json:"word"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+func validLocale(locale string) bool {
+	if locale == "" || len(locale) > 16 {
+		return false
+	}
+	for _, c := range locale {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// inLocale returns the word for locale, "en-US" falls back to "en"
+func (w *TopicWord) inLocale(locale string) *LocaleWord {
+	if localeWord, exist := w.Locales[locale]; exist {
+		return localeWord
+	}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		if localeWord, exist := w.Locales[locale[:i]]; exist {
+			return localeWord
+		}
+	}
+	return nil
+}
+
+// normalizeTopic merges the plain topics into words as defaultLocale words,
+// then lists the defaultLocale words in topics again for the old clients
+func normalizeTopic(topic *Topic) *Topic {
+	return normalizeTopicIn(topic, defaultLocale)
+}
+
+// normalizeTopicIn is normalizeTopic with the plain topics in locale
+func normalizeTopicIn(topic *Topic, locale string) *Topic {
+	newTopic := &Topic{}
+	seen := map[string]bool{}
+	for _, word := range topic.Words {
+		if word == nil || len(word.Locales) == 0 {
+			continue
+		}
+		if localeWord := word.Locales[locale]; localeWord != nil {
+			seen[localeWord.Word] = true
+		}
+		newTopic.Words = append(newTopic.Words, word)
+	}
+	for _, item := range topic.Topics {
+		if !seen[item] {
+			seen[item] = true
+			newTopic.Words = append(newTopic.Words, &TopicWord{Locales: map[string]*LocaleWord{locale: {Word: item}}})
+		}
+	}
+	newTopic.Topics = wordsOf(newTopic.Words, locale)
+	return newTopic
+}
+
+func newTopicWord(word string) *TopicWord {
+	return &TopicWord{Locales: map[string]*LocaleWord{defaultLocale: {Word: word}}}
+}
+
+func wordsOf(words []*TopicWord, locale string) []string {
+	result := []string{}
+	for _, word := range words {
+		if localeWord := word.inLocale(locale); localeWord != nil {
+			result = append(result, localeWord.Word)
+		}
+	}
+	return result
+}
+
+// compactTopic is the topic written to the file, words only holding a defaultLocale
+// word without alias and difficulty are kept in the plain topics list
+func compactTopic(topic *Topic) *Topic {
+	fileTopic := &Topic{Topics: []string{}}
+	for _, word := range topic.Words {
+		localeWord := word.Locales[defaultLocale]
+		if len(word.Locales) == 1 && localeWord != nil && len(localeWord.Aliases) == 0 && word.Difficulty == "" {
+			fileTopic.Topics = append(fileTopic.Topics, localeWord.Word)
+		} else {
+			fileTopic.Words = append(fileTopic.Words, word)
+		}
+	}
+	return fileTopic
+}
+
+// localeTopics lists the topics of every category in locale
+func localeTopics(locale string) map[string]*Topic {
+	result := map[string]*Topic{}
+	for category, topic := range topicStore.All() {
+		words := wordsOf(topic.Words, locale)
+		if len(words) > 0 {
+			result[category] = &Topic{Topics: words}
+		}
+	}
+	return result
+}
+
+// pickedTopic is the topic of a round with its accepted answers
+type pickedTopic struct {
+	Category string
+	Topic    string
+	Answers  []string
+	Key      string // key of the topic statistics, empty for custom words
+}
+
+// randomTopic picks a random topic in locale at difficulty from the global topics
+// and the packs, any difficulty is picked when there is none at that difficulty
+func randomTopic(locale string, difficulty string, packIds ...string) *pickedTopic {
+	candidates := []*pickedTopic{}
+	matched := []*pickedTopic{}
+	eachTopic(packIds, func(packId string, category string, topic *Topic) {
+		for _, word := range topic.Words {
+			localeWord := word.inLocale(locale)
+			if localeWord == nil {
+				continue
+			}
+			answers := append([]string{localeWord.Word}, localeWord.Aliases...)
+			picked := &pickedTopic{category, localeWord.Word, answers, packTopicKey(packId, category, word)}
+			candidates = append(candidates, picked)
+			if matchDifficulty(picked.Key, word, difficulty) {
+				matched = append(matched, picked)
+			}
+		}
+	})
+	if len(matched) > 0 {
+		candidates = matched
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+func countLocaleTopics(locale string, packIds ...string) int {
+	count := 0
+	eachTopic(packIds, func(packId string, category string, topic *Topic) {
+		count += len(wordsOf(topic.Words, locale))
+	})
+	return count
+}
+
+func normalizeAnswer(answer string) string {
+	return strings.ToLower(strings.TrimSpace(answer))
+}
+
+// matchAnswer checks the answer against the topic of the room and its aliases
+func matchAnswer(room *Room, answer string) bool {
+	answer = normalizeAnswer(answer)
+	if answer == "" {
+		return false
+	}
+	if room.TopicDetail != nil && normalizeAnswer(room.TopicDetail.Topic) == answer {
+		return true
+	}
+	for _, item := range room.round.answers {
+		if normalizeAnswer(item) == answer {
+			return true
+		}
+	}
+	return false
+}
+
+func roomLocale(room *Room) string {
+	if room.Locale == "" {
+		return defaultLocale
+	}
+	return room.Locale
+}
+
+func requestLocale(lang string) string {
+	if lang == "" {
+		return defaultLocale
+	}
+	return lang
+}
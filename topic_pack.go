@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	ratingEveryone = "everyone"
+	ratingTeen     = "teen"
+	ratingMature   = "mature"
+)
+
+var packDir = "sample/pack/" // one json file per word pack
+var maxRoomPacks = 10
+
+// TopicPack is a shareable word pack, its plain topics are words in its locale
+type TopicPack struct {
+	Id            string            `json:"id"`
+	Name          string            `json:"name"`
+	Author        string            `json:"author,omitempty"`
+	Version       string            `json:"version"`
+	Locale        string            `json:"locale,omitempty"`
+	ContentRating string            `json:"contentRating,omitempty"` // everyone, teen or mature
+	Categories    map[string]*Topic `json:"categories"`
+}
+
+// PackBean is a pack without its words, listed by /topic/packs
+type PackBean struct {
+	Id            string   `json:"id"`
+	Name          string   `json:"name"`
+	Author        string   `json:"author,omitempty"`
+	Version       string   `json:"version"`
+	Locale        string   `json:"locale"`
+	ContentRating string   `json:"contentRating"`
+	Categories    []string `json:"categories"`
+	Words         int      `json:"words"`
+}
+
+// RoomPacksBean sets the packs of a room, userId must be the host
+type RoomPacksBean struct {
+	UserId    string   `json:"userId,omitempty"`
+	UserToken string   `json:"userToken,omitempty"` // secret of userId from the join response
+	Packs     []string `json:"packs"`
+	Result    *bool    `json:"result,omitempty"`
+}
+
+var (
+	errPackNotFound = &apiError{http.StatusNotFound, "pack_not_found", "pack is not exist"}
+	errInvalidPacks = &apiError{http.StatusUnprocessableEntity, "invalid_packs", "packs are too many or not exist"}
+)
+
+var packsValue atomic.Value // store map[string]*TopicPack by id, swapped as a whole on reload
+
+func getPacks() map[string]*TopicPack {
+	packs, _ := packsValue.Load().(map[string]*TopicPack)
+	return packs
+}
+
+func getPack(packId string) (*TopicPack, bool) {
+	pack, exist := getPacks()[packId]
+	return pack, exist
+}
+
+// compareVersion compares dotted versions by number, "1.10" is newer than "1.9"
+func compareVersion(a string, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aNumber, bNumber := 0, 0
+		if i < len(aParts) {
+			aNumber, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNumber, _ = strconv.Atoi(bParts[i])
+		}
+		if aNumber != bNumber {
+			if aNumber < bNumber {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func validVersion(version string) bool {
+	if version == "" {
+		return false
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func validContentRating(rating string) bool {
+	return rating == ratingEveryone || rating == ratingTeen || rating == ratingMature
+}
+
+// readPack reads and normalizes one pack file, the id is the file name if not given
+func readPack(fileName string) (*TopicPack, string) {
+	pack := &TopicPack{}
+	if err := readJSONFile(packDir+fileName, pack); err != nil {
+		return nil, err.Error()
+	}
+	if pack.Id == "" {
+		pack.Id = strings.TrimSuffix(fileName, ".json")
+	}
+	if !validCategoryName(pack.Id) {
+		return nil, "pack id is not valid"
+	}
+	if pack.Name == "" {
+		return nil, "pack name is required"
+	}
+	if !validVersion(pack.Version) {
+		return nil, "version must be numbers separated by dots, e.g. 1.2.0"
+	}
+	if pack.Locale == "" {
+		pack.Locale = defaultLocale
+	} else if !validLocale(pack.Locale) {
+		return nil, "locale is not valid"
+	}
+	if pack.ContentRating == "" {
+		pack.ContentRating = ratingEveryone
+	} else if !validContentRating(pack.ContentRating) {
+		return nil, "contentRating must be everyone, teen or mature"
+	}
+	if len(pack.Categories) == 0 {
+		return nil, "pack has no category"
+	}
+	for category, topic := range pack.Categories {
+		if topic == nil || hasEmptyTopic(topic) || hasInvalidDifficulty(topic) {
+			return nil, category + " has an empty word, an invalid locale or difficulty"
+		}
+		pack.Categories[category] = normalizeTopicIn(topic, pack.Locale)
+	}
+	return pack, ""
+}
+
+// reloadPacks reads every pack in packDir, an invalid pack file is skipped and
+// the newest version is kept when files have the same pack id
+func reloadPacks() {
+	files, err := ioutil.ReadDir(packDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			appLog.error("pack dir fail", "err", err)
+		}
+		packsValue.Store(map[string]*TopicPack{})
+		return
+	}
+	packs := map[string]*TopicPack{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		pack, failure := readPack(file.Name())
+		if pack == nil {
+			appLog.warn("pack file fail", "file", file.Name(), "failure", failure)
+			continue
+		}
+		if loaded, exist := packs[pack.Id]; exist && compareVersion(loaded.Version, pack.Version) >= 0 {
+			continue
+		}
+		packs[pack.Id] = pack
+	}
+	packsValue.Store(packs)
+	appLog.info("packs load success", "packs", len(packs))
+}
+
+func newPackBean(pack *TopicPack) PackBean {
+	bean := PackBean{Id: pack.Id, Name: pack.Name, Author: pack.Author, Version: pack.Version,
+		Locale: pack.Locale, ContentRating: pack.ContentRating, Categories: []string{}}
+	for category, topic := range pack.Categories {
+		bean.Categories = append(bean.Categories, category)
+		bean.Words += len(topic.Words)
+	}
+	sort.Strings(bean.Categories)
+	return bean
+}
+
+// listPackBeans lists the packs by id, only those with words in locale if given
+func listPackBeans(locale string) []PackBean {
+	packBeans := []PackBean{}
+	for _, pack := range getPacks() {
+		if locale != "" && countPackTopics(pack, locale) == 0 {
+			continue
+		}
+		packBeans = append(packBeans, newPackBean(pack))
+	}
+	sort.Slice(packBeans, func(i, j int) bool {
+		return packBeans[i].Id < packBeans[j].Id
+	})
+	return packBeans
+}
+
+func countPackTopics(pack *TopicPack, locale string) int {
+	count := 0
+	for _, topic := range pack.Categories {
+		count += len(wordsOf(topic.Words, locale))
+	}
+	return count
+}
+
+// eachTopic calls fn with every global category and every category of the packs,
+// packId is empty for the global topics and unknown packs are skipped
+func eachTopic(packIds []string, fn func(packId string, category string, topic *Topic)) {
+	for category, topic := range topicStore.All() {
+		fn("", category, topic)
+	}
+	for _, packId := range packIds {
+		pack, exist := getPack(packId)
+		if !exist {
+			continue
+		}
+		for category, topic := range pack.Categories {
+			fn(packId, category, topic)
+		}
+	}
+}
+
+// packTopicKey is topicKey prefixed by the pack id for the topics of a pack
+func packTopicKey(packId string, category string, word *TopicWord) string {
+	if packId == "" {
+		return topicKey(category, word)
+	}
+	return packId + ":" + topicKey(category, word)
+}
+
+// checkPacks dedups the pack ids, every pack must be loaded
+func checkPacks(packIds []string) ([]string, *apiError) {
+	if len(packIds) == 0 {
+		return nil, nil
+	}
+	packIds = uniqueTopics(nil, packIds)
+	if len(packIds) > maxRoomPacks {
+		return nil, errInvalidPacks
+	}
+	for _, packId := range packIds {
+		if _, exist := getPack(packId); !exist {
+			return nil, errInvalidPacks
+		}
+	}
+	return packIds, nil
+}
+
+func setRoomPacks(roomId string, bean *RoomPacksBean) *apiError {
+	room, roomExist := roomStore.Get(roomId)
+	if !roomExist {
+		return errRoomNotFound
+	}
+	if !isRoomHost(room, bean.UserId, bean.UserToken) {
+		return errNotHost
+	}
+	bean.UserToken = "" // not written back
+	packs, apiErr := checkPacks(bean.Packs)
+	if apiErr != nil {
+		return apiErr
+	}
+	room.Packs = packs
+	bean.Packs = packs
+	saveRoom(room)
+	return nil
+}
+
+func apiPackListHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(w, http.StatusOK, listPackBeans(r.URL.Query().Get("lang")))
+}
+
+func apiPackHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	pack, exist := getPack(params["packId"])
+	if !exist {
+		writeAPIError(w, errPackNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, pack)
+}
+
+func apiRoomPacksHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	bean := &RoomPacksBean{}
+	err := json.NewDecoder(r.Body).Decode(bean)
+	if err != nil {
+		writeAPIError(w, errBadRequest)
+		return
+	}
+	if apiErr := setRoomPacks(params["roomId"], bean); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	result := true
+	bean.Result = &result
+	writeJSON(w, http.StatusOK, bean)
+}
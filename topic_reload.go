@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var topicReloadInterval = 5 * time.Second // how often topicDir is checked for changes, 0 disables it
+
+// TopicReloadReport tells whether a reload was applied and which files failed
+type TopicReloadReport struct {
+	Loaded     bool              `json:"loaded"`
+	Categories int               `json:"categories"`
+	Topics     int               `json:"topics"`
+	Failures   map[string]string `json:"failures,omitempty"`
+}
+
+// readTopics reads config.json and every category file into a new map
+// without touching the current topics
+func readTopics() (map[string]*Topic, *TopicReloadReport) {
+	report := &TopicReloadReport{Failures: map[string]string{}}
+	newTopics := map[string]*Topic{}
+	category := &Category{}
+	err := getSampleTopicFile("config.json", category)
+	if err != nil {
+		report.Failures["config.json"] = err.Error()
+		return newTopics, report
+	}
+	for _, name := range category.Category {
+		fileName := name + ".json"
+		if !validCategoryName(name) {
+			report.Failures[fileName] = "category name is not a valid file name"
+			continue
+		}
+		topic := &Topic{}
+		err = getSampleTopicFile(fileName, topic)
+		if err != nil {
+			report.Failures[fileName] = err.Error()
+			continue
+		}
+		if hasEmptyTopic(topic) {
+			report.Failures[fileName] = "category has an empty topic or a word without locale"
+			continue
+		}
+		if hasInvalidDifficulty(topic) {
+			report.Failures[fileName] = "difficulty must be easy, medium or hard"
+			continue
+		}
+		topic = normalizeTopic(topic)
+		if len(topic.Words) == 0 {
+			report.Failures[fileName] = "category has no topic"
+			continue
+		}
+		newTopics[name] = topic
+		report.Topics += len(topic.Words)
+	}
+	report.Categories = len(newTopics)
+	return newTopics, report
+}
+
+func hasEmptyTopic(topic *Topic) bool {
+	for _, item := range topic.Topics {
+		if strings.TrimSpace(item) == "" {
+			return true
+		}
+	}
+	for _, word := range topic.Words {
+		if word == nil || len(word.Locales) == 0 {
+			return true
+		}
+		for locale, localeWord := range word.Locales {
+			if !validLocale(locale) || localeWord == nil || strings.TrimSpace(localeWord.Word) == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasInvalidDifficulty(topic *Topic) bool {
+	for _, word := range topic.Words {
+		if word.Difficulty != "" && word.Difficulty != difficultyEasy &&
+			word.Difficulty != difficultyMedium && word.Difficulty != difficultyHard {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadTopics swaps topics with the files in topicDir only if every file is valid
+func reloadTopics() *TopicReloadReport {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	newTopics, report := readTopics()
+	if len(report.Failures) > 0 {
+		logTopicFailures(report)
+		return report
+	}
+	topicStore.Load(newTopics)
+	report.Loaded = true
+	return report
+}
+
+// loadTopics loads the valid files in topicDir at startup and reports the failed ones,
+// a bad file must not leave the server without any topic
+func loadTopics() *TopicReloadReport {
+	topicsMutex.Lock()
+	defer topicsMutex.Unlock()
+	newTopics, report := readTopics()
+	logTopicFailures(report)
+	if len(newTopics) == 0 {
+		return report
+	}
+	topicStore.Load(newTopics)
+	report.Loaded = true
+	return report
+}
+
+func logTopicFailures(report *TopicReloadReport) {
+	fileNames := make([]string, 0, len(report.Failures))
+	for fileName := range report.Failures {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	for _, fileName := range fileNames {
+		appLog.warn("topic file fail", "file", fileName, "failure", report.Failures[fileName])
+	}
+}
+
+// topicDirSignature changes when any json file in topicDir or packDir is changed, added or removed
+func topicDirSignature() string {
+	return dirSignature(topicDir) + "|" + dirSignature(packDir)
+}
+
+func dirSignature(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	signature := ""
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		signature += fmt.Sprintf("%s:%d:%d;", file.Name(), file.Size(), file.ModTime().UnixNano())
+	}
+	return signature
+}
+
+// watchTopics reloads the topics on SIGHUP or when the files in topicDir change
+func watchTopics() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if topicReloadInterval > 0 {
+		ticker := time.NewTicker(topicReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	signature := topicDirSignature()
+	for {
+		select {
+		case <-hangup:
+			appLog.info("SIGHUP, reload topics")
+		case <-tick:
+			newSignature := topicDirSignature()
+			if newSignature == signature {
+				continue
+			}
+			appLog.info("topic files changed, reload topics")
+		}
+		signature = topicDirSignature()
+		reloadPacks()
+		report := reloadTopics()
+		if report.Loaded {
+			appLog.info("topics reload success")
+		} else {
+			appLog.error("topics reload fail, keep the current topics")
+		}
+	}
+}
+
+func apiTopicReloadHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	reloadPacks()
+	report := reloadTopics()
+	if !report.Loaded {
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}